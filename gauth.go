@@ -2,10 +2,20 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"text/tabwriter"
@@ -13,367 +23,1607 @@ import (
 
 	"github.com/creachadair/otp/otpauth"
 	"github.com/pcarrier/gauth/gauth"
+	"github.com/pcarrier/gauth/internal/qr"
 	"golang.org/x/term"
 )
 
+// A command is invoked as "gauth [global flags] <name> [args]".
 type command struct {
-	name        string
-	shortFlag   string
-	longFlags   []string
-	description string
-	handler     func(string, []*otpauth.URL)
+	name           string
+	usage          string
+	description    string
+	writes         bool // whether the command modifies the config
+	optionalConfig bool // whether the command runs before the config exists
+	formats        bool // whether the command shows JSON with --format json
+	handler        func(args []string)
 }
 
 var commands = []command{
 	{
-		name:        "bare",
-		shortFlag:   "-b",
-		longFlags:   []string{"-bare", "--bare"},
-		description: "Print bare code for account",
-		handler:     func(acc string, urls []*otpauth.URL) { printBareCode(acc, urls) },
+		name:        "list",
+		usage:       "list [-by-issuer|-by-tag] [ACCOUNT]",
+		description: "Show codes, of all accounts or those ACCOUNT selects",
+		formats:     true,
+		handler:     listCodes,
 	},
 	{
-		name:        "add",
-		shortFlag:   "-a",
-		longFlags:   []string{"-add", "--add"},
-		description: "Add new account",
-		handler:     func(acc string, _ []*otpauth.URL) { addCode(acc) },
+		name:        "code",
+		usage:       "code ACCOUNT",
+		description: "Print the current code of an account",
+		formats:     true,
+		handler:     func(args []string) { printBareCode(accountArg("code", args), getUrls()) },
+	},
+	{
+		name:        "next",
+		usage:       "next ACCOUNT",
+		description: "Print the next code of an HOTP account and advance its counter",
+		writes:      true,
+		formats:     true,
+		handler:     func(args []string) { printNextCode(accountArg("next", args), getUrls()) },
+	},
+	{
+		name:           "add",
+		usage:          "add ACCOUNT|--qr IMAGE",
+		description:    "Add an account, asking for its key, or those of a QR code in a PNG, JPEG or GIF image",
+		writes:         true,
+		optionalConfig: true,
+		handler:        addAccounts,
 	},
 	{
 		name:        "remove",
-		shortFlag:   "-r",
-		longFlags:   []string{"-remove", "--remove"},
-		description: "Remove account",
-		handler:     func(acc string, _ []*otpauth.URL) { removeCode(acc) },
+		usage:       "remove ACCOUNT",
+		description: "Remove the accounts ACCOUNT selects",
+		writes:      true,
+		handler:     func(args []string) { removeCode(accountArg("remove", args)) },
 	},
 	{
 		name:        "secret",
-		shortFlag:   "-s",
-		longFlags:   []string{"-secret", "--secret"},
-		description: "Show secret for account",
-		handler:     func(acc string, urls []*otpauth.URL) { printSecret(acc, urls) },
+		usage:       "secret ACCOUNT",
+		description: "Print the secret of an account",
+		handler:     func(args []string) { printSecret(accountArg("secret", args), getUrls()) },
+	},
+	{
+		name:        "url",
+		usage:       "url ACCOUNT",
+		description: "Show the otpauth URL and QR code of an account",
+		handler:     func(args []string) { printURL(accountArg("url", args), getUrls()) },
+	},
+	{
+		name:        "migrate",
+		usage:       "migrate",
+		description: "Convert an OpenSSL-encrypted config to the native vault format",
+		writes:      true,
+		handler:     func([]string) { migrateConfig() },
+	},
+	{
+		name:        "encrypt",
+		usage:       "encrypt",
+		description: "Encrypt a plaintext config as a native vault",
+		writes:      true,
+		handler:     func([]string) { encryptConfig() },
+	},
+	{
+		name:        "decrypt",
+		usage:       "decrypt",
+		description: "Decrypt an encrypted config back to plaintext",
+		writes:      true,
+		handler:     func([]string) { decryptConfig() },
+	},
+	{
+		name:        "passwd",
+		usage:       "passwd",
+		description: "Change the encryption password",
+		writes:      true,
+		handler:     func([]string) { changePassword() },
+	},
+	{
+		name:        "restore",
+		usage:       "restore [N]",
+		description: "List backups, or roll back to backup N",
+		writes:      true,
+		handler:     restoreBackup,
+	},
+	{
+		name:           "import",
+		usage:          "import [-y] [-name NAME] [-format andotp] [URL|FILE|-]...",
+		description:    "Import accounts exported by other authenticator apps",
+		writes:         true,
+		optionalConfig: true,
+		handler:        importAccounts,
+	},
+	{
+		name:        "add-tag",
+		usage:       "add-tag ACCOUNT TAG...",
+		description: "Tag the accounts ACCOUNT selects",
+		writes:      true,
+		handler:     func(args []string) { tagAccounts(args, false) },
+	},
+	{
+		name:        "remove-tag",
+		usage:       "remove-tag ACCOUNT TAG...",
+		description: "Remove tags from the accounts ACCOUNT selects",
+		writes:      true,
+		handler:     func(args []string) { tagAccounts(args, true) },
+	},
+	{
+		name:           "copy",
+		usage:          "copy [-create] ACCOUNT VAULT",
+		description:    "Copy the accounts ACCOUNT selects to another vault",
+		writes:         true,
+		optionalConfig: true,
+		handler:        func(args []string) { transferAccounts(args, false) },
+	},
+	{
+		name:           "move",
+		usage:          "move [-create] ACCOUNT VAULT",
+		description:    "Move the accounts ACCOUNT selects to another vault",
+		writes:         true,
+		optionalConfig: true,
+		handler:        func(args []string) { transferAccounts(args, true) },
+	},
+	{
+		name:        "export",
+		usage:       "export [-format F] [account]...",
+		description: "Show accounts as Google Authenticator QR codes, or export them to other apps",
+		handler:     exportAccounts,
 	},
 }
 
-var (
-	cachedRaw  []byte
-	cachedUrls []*otpauth.URL
-)
+// aliases are the flags of the original command line, "gauth ACCOUNT FLAG",
+// without their dashes, and the commands they stand for. They may also come
+// first, as in "gauth -b ACCOUNT".
+var aliases = []struct {
+	flags   []string
+	command []string
+}{
+	{[]string{"b", "bare"}, []string{"code"}},
+	{[]string{"n", "next"}, []string{"next"}},
+	{[]string{"a", "add"}, []string{"add"}},
+	{[]string{"r", "remove"}, []string{"remove"}},
+	{[]string{"s", "secret"}, []string{"secret"}},
+	{[]string{"u", "url"}, []string{"url"}},
+	{[]string{"g", "group"}, []string{"list", "-by-issuer"}},
+	{[]string{"t", "by-tag"}, []string{"list", "-by-tag"}},
+}
+
+const defaultLockTimeout = 10 * time.Second
+
+// vaultNames are the vaults picked with --vault, the default config being
+// "". Commands other than listing codes need a single one.
+var vaultNames = []string{""}
+
+var cachedVaults = make(map[string]*gauth.Vault)
+
+// outputFormat is how codes are shown, text or json, as picked with
+// --format.
+var outputFormat = "text"
+
+// codeTime is the time picked with --time to show codes at, or zero for now.
+var codeTime time.Time
 
 func findCommand(arg string) *command {
 	for i := range commands {
-		if arg == commands[i].shortFlag {
+		if arg == commands[i].name {
 			return &commands[i]
 		}
-		for _, f := range commands[i].longFlags {
-			if arg == f {
-				return &commands[i]
-			}
+	}
+	return nil
+}
+
+// findAlias returns the command that arg, a flag of the original command
+// line, stands for, or nil.
+func findAlias(arg string) []string {
+	name, ok := strings.CutPrefix(arg, "-")
+	if !ok {
+		return nil
+	}
+	name = strings.TrimPrefix(name, "-")
+	for _, a := range aliases {
+		if slices.Contains(a.flags, name) {
+			return a.command
 		}
 	}
 	return nil
 }
 
+// dispatch returns the command that args, the arguments left after the
+// global flags, run, and the arguments to pass it. alias is the command a
+// flag of the original command line among the global flags stands for, or
+// nil. The command is nil for gauth help.
+func dispatch(alias, args []string) (*command, []string) {
+	if alias == nil && len(args) > 1 && args[0] != "help" && findCommand(args[0]) == nil {
+		// "gauth ACCOUNT -b" puts the flag after the account.
+		if a := findAlias(args[1]); a != nil {
+			alias, args = a, append([]string{args[0]}, args[2:]...)
+		}
+	}
+	switch {
+	case alias != nil:
+		return findCommand(alias[0]), append(append(slices.Clone(alias[1:]), "--"), args...)
+	case len(args) == 0:
+		return findCommand("list"), nil
+	case args[0] == "help":
+		return nil, nil
+	}
+	if cmd := findCommand(args[0]); cmd != nil {
+		return cmd, args[1:]
+	}
+	// "gauth ACCOUNT" shows the codes of an account.
+	return findCommand("list"), append([]string{"--"}, args...)
+}
+
 func printUsage() {
-	fmt.Println("Usage: gauth [account] [command]")
+	fmt.Println("Usage: gauth [global flags] [command] [args]")
+	fmt.Println("\nGlobal flags:")
+	fmt.Printf("  %-25s %s\n", "--vault NAME,...|all", "Use named vaults rather than the default config")
+	fmt.Printf("  %-25s %s\n", "--format text|json", "Show codes as text or as JSON")
+	fmt.Printf("  %-25s %s\n", "--time TIME", "Show codes at TIME, in RFC 3339 or Unix seconds, rather than now")
 	fmt.Println("\nCommands:")
 	for _, cmd := range commands {
-		flags := append([]string{cmd.shortFlag}, cmd.longFlags...)
-		fmt.Printf("  %-25s %s\n", strings.Join(flags, ", "), cmd.description)
+		fmt.Printf("  %-25s %s\n", cmd.usage, cmd.description)
+	}
+	fmt.Printf("  %-25s %s\n", "help", "Show this help")
+	fmt.Println("\nWithout a command, gauth lists codes, and the flags of earlier versions stand for commands:")
+	for _, a := range aliases {
+		account := "ACCOUNT"
+		if a.command[0] == "list" {
+			account = "[ACCOUNT]"
+		}
+		flags := account + " -" + a.flags[0] + ", --" + a.flags[1]
+		fmt.Printf("  %-25s gauth %s %s\n", flags, strings.Join(a.command, " "), account)
 	}
 	fmt.Println("\nExamples:")
 	fmt.Println("  gauth                     # Show all codes")
-	fmt.Println("  gauth github              # Show codes for an account (partial matches supported)")
-	fmt.Println("  gauth github -b           # Show current code for an account")
-	fmt.Println("  gauth github --add        # Add new account")
+	fmt.Println("  gauth list github         # Show codes for an account (partial matches supported)")
+	fmt.Println("  gauth list issuer:GitHub  # Show codes for an issuer's accounts (also globs and /regexps/)")
+	fmt.Println("  gauth code github         # Print current code for an account")
+	fmt.Println("  gauth github -b           # The same, as earlier versions did")
+	fmt.Println("  gauth list -by-issuer     # Show all codes grouped by issuer")
+	fmt.Println("  gauth list tag:prod       # Show codes for accounts tagged prod")
+	fmt.Println("  gauth add-tag vpn prod    # Tag an account")
+	fmt.Println("  gauth --vault work        # Show codes of the work vault")
+	fmt.Println("  gauth --vault all         # Show codes of all vaults")
+	fmt.Println("  gauth move vpn work       # Move an account to the work vault")
+	fmt.Println("  gauth next vpn            # Show next code for an HOTP account")
+	fmt.Println("  gauth add github          # Add new account")
+	fmt.Println("  gauth url github          # Show account's QR code, to scan it with a phone")
+	fmt.Println("  gauth --format json       # Show all codes as JSON, for scripts")
+	fmt.Println("  gauth --time 2024-06-01T12:00:00Z code github")
+	fmt.Println("                            # Print the code an account had at noon UTC")
 }
 
 func isHelpFlag(arg string) bool {
 	return arg == "-h" || arg == "--help"
 }
 
-func shouldShowHelp() bool {
-	for _, a := range os.Args[1:] {
-		if isHelpFlag(a) {
-			return true
-		}
+// accountArg returns the single argument of the command named name, an
+// account selector, which may follow "--".
+func accountArg(name string, args []string) string {
+	if len(args) == 2 && args[0] == "--" {
+		return args[1]
 	}
-	cfgPath := getConfigPath()
-	if _, err := os.Stat(cfgPath); os.IsNotExist(err) {
-		if len(os.Args) > 2 {
-			if cmd := findCommand(os.Args[2]); cmd != nil && cmd.name == "add" {
-				return false
-			}
-		}
-		fmt.Printf("No config file found at %s\n\n", cfgPath)
-		return true
+	if len(args) != 1 || isHelpFlag(args[0]) {
+		log.Fatalf("Usage: gauth %s ACCOUNT", name)
 	}
-	return false
+	return args[0]
 }
 
-func matchAccount(pattern, account string) bool {
-	return strings.Contains(strings.ToLower(account), strings.ToLower(pattern))
+// selectAccounts returns the accounts of urls picked by selector, as
+// gauth.Select does, falling back to partial matches if partial is set.
+func selectAccounts(selector string, urls []*otpauth.URL, partial bool) []*otpauth.URL {
+	selected, err := gauth.Select(urls, accountTags, selector, partial)
+	if err != nil {
+		log.Fatalf("Invalid selector %q: %v", selector, err)
+	}
+	return selected
 }
 
-func main() {
-	if shouldShowHelp() {
-		printUsage()
-		return
+// selectAccount returns the single account of urls picked by selector,
+// failing if there is none or several.
+func selectAccount(selector string, urls []*otpauth.URL) *otpauth.URL {
+	selected := selectAccounts(selector, urls, true)
+	switch len(selected) {
+	case 0:
+		log.Fatalf("No account matches %q", selector)
+	case 1:
+		return selected[0]
 	}
-
-	var accountName string
-	if len(os.Args) > 1 && !isHelpFlag(os.Args[1]) {
-		accountName = os.Args[1]
+	var names []string
+	for _, url := range selected {
+		names = append(names, displayName(url))
 	}
+	log.Fatalf("%q matches %d accounts, pick one with an exact name: %s", selector, len(selected), strings.Join(names, ", "))
+	return nil
+}
 
-	var cmd *command
-	if len(os.Args) > 2 {
-		cmd = findCommand(os.Args[2])
+func main() {
+	if spec := os.Getenv("GAUTH_ENCRYPTION"); spec != "" {
+		enc, err := gauth.ParseEncryption(spec)
+		if err != nil {
+			log.Fatalf("Parsing GAUTH_ENCRYPTION: %v", err)
+		}
+		gauth.Encryptions = append([]gauth.Encryption{enc}, gauth.Encryptions...)
+	}
+	if n := os.Getenv("GAUTH_BACKUPS"); n != "" {
+		count, err := strconv.Atoi(n)
+		if err != nil || count < 0 {
+			log.Fatalf("Invalid GAUTH_BACKUPS %q: want a number of backups to keep", n)
+		}
+		gauth.BackupCount = count
 	}
 
-	if cmd != nil {
-		var urls []*otpauth.URL
-		if cmd.name != "add" {
-			urls = getUrls()
+	flags := flag.NewFlagSet("gauth", flag.ExitOnError)
+	vaults := flags.String("vault", os.Getenv("GAUTH_VAULT"), "")
+	flags.StringVar(&outputFormat, "format", outputFormat, "")
+	at := flags.String("time", "", "")
+	var alias []string
+	for _, a := range aliases {
+		for _, name := range a.flags {
+			flags.BoolFunc(name, "", func(string) error {
+				alias = a.command
+				return nil
+			})
 		}
-		cmd.handler(accountName, urls)
+	}
+	flags.Usage = printUsage
+	_ = flags.Parse(os.Args[1:]) // exits on error
+	vaultNames = pickVaults(*vaults)
+	if outputFormat != "text" && outputFormat != "json" {
+		log.Fatalf("Invalid --format %q: want text or json", outputFormat)
+	}
+	if *at != "" {
+		codeTime = parseTime(*at)
+	}
+
+	cmd, args := dispatch(alias, flags.Args())
+	if cmd == nil {
+		printUsage()
 		return
 	}
 
-	printCodes(getUrls(), accountName)
+	if outputFormat != "text" && !cmd.formats {
+		log.Fatalf("gauth %s does not support --format %s", cmd.name, outputFormat)
+	}
+	if len(vaultNames) == 1 && !cmd.optionalConfig {
+		cfgPath := getConfigPath()
+		if _, err := os.Stat(cfgPath); os.IsNotExist(err) {
+			fmt.Printf("No config file found at %s\n\n", cfgPath)
+			printUsage()
+			return
+		}
+	}
+	if cmd.writes {
+		defer lockConfig().Unlock()
+	}
+	cmd.handler(args)
 }
 
 func getPassword() ([]byte, error) {
-	fmt.Print("Encryption password: ")
+	return readPassword(passwordPrompt(currentVault()))
+}
+
+// passwordPrompt asks for the password of the vault named name.
+func passwordPrompt(name string) string {
+	if name == "" {
+		return "Encryption password: "
+	}
+	return fmt.Sprintf("Encryption password of vault %s: ", name)
+}
+
+// readPassword asks for a password, from the terminal even if standard input
+// is redirected.
+func readPassword(prompt string) ([]byte, error) {
+	fmt.Print(prompt)
 	defer fmt.Println()
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		// Stdin may carry data, e.g. for import; prefer the terminal.
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			defer tty.Close()
+			return term.ReadPassword(int(tty.Fd()))
+		}
+	}
 	return term.ReadPassword(int(syscall.Stdin))
 }
 
+// getNewPassword asks for a new password twice, and fails unless both
+// entries match.
+func getNewPassword() []byte {
+	return readNewPassword("encryption password")
+}
+
+// readNewPassword asks for a new, non-empty password twice, from the
+// terminal as readPassword does.
+func readNewPassword(what string) []byte {
+	pass, err := readPassword(fmt.Sprintf("New %s: ", what))
+	if err != nil {
+		log.Fatalf("Reading passphrase: %v", err)
+	}
+	if len(pass) == 0 {
+		log.Fatal("The password must not be empty")
+	}
+	confirm, err := readPassword(fmt.Sprintf("Confirm %s: ", what))
+	if err != nil {
+		log.Fatalf("Reading passphrase: %v", err)
+	}
+	if !bytes.Equal(pass, confirm) {
+		log.Fatal("Passwords do not match")
+	}
+	return pass
+}
+
 func getConfigPath() string {
-	if cfg := os.Getenv("GAUTH_CONFIG"); cfg != "" {
-		return cfg
+	return vaultPath(currentVault())
+}
+
+// currentVault returns the name of the single vault picked with --vault.
+func currentVault() string {
+	if len(vaultNames) > 1 {
+		log.Fatal("This command works on a single vault, pick one with --vault NAME")
+	}
+	return vaultNames[0]
+}
+
+// vaultPath returns the path of the vault named name: the default config,
+// or NAME.csv in the vaults directory.
+func vaultPath(name string) string {
+	if name == "" {
+		if cfg := os.Getenv("GAUTH_CONFIG"); cfg != "" {
+			return cfg
+		}
+		return filepath.Join(configDir(), "gauth.csv")
+	}
+	return filepath.Join(vaultsDir(), name+".csv")
+}
+
+// vaultsDir returns the directory of named vaults, GAUTH_VAULTS or
+// ~/.config/gauth.
+func vaultsDir() string {
+	if dir := os.Getenv("GAUTH_VAULTS"); dir != "" {
+		return dir
 	}
+	return filepath.Join(configDir(), "gauth")
+}
+
+func configDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		log.Fatalf("Getting home directory: %v", err)
 	}
-	return filepath.Join(home, ".config", "gauth.csv")
+	return filepath.Join(home, ".config")
 }
 
-func loadConfig() error {
-	if cachedRaw != nil {
-		return nil
+// vaultDisplayName returns how messages name the vault named name.
+func vaultDisplayName(name string) string {
+	if name == "" {
+		return "default"
 	}
+	return name
+}
 
-	cfgPath := getConfigPath()
-	raw, err := gauth.LoadConfigFile(cfgPath, getPassword)
+// parseVaultName returns the vault named name on the command line, where
+// "default" is the default config.
+func parseVaultName(name string) (string, error) {
+	switch {
+	case name == "default":
+		return "", nil
+	case name == "all" || name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\:,"):
+		return "", fmt.Errorf("invalid vault name %q", name)
+	}
+	return name, nil
+}
+
+// parseVaults returns the vaults picked by spec, a comma-separated list of
+// vault names, or "all" for the default config and every named vault.
+func parseVaults(spec string) ([]string, error) {
+	if spec == "all" {
+		names := []string{""}
+		matches, err := filepath.Glob(filepath.Join(vaultsDir(), "*.csv"))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if name, err := parseVaultName(strings.TrimSuffix(filepath.Base(m), ".csv")); err == nil && name != "" {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+	var names []string
+	for _, n := range strings.Split(spec, ",") {
+		name, err := parseVaultName(strings.TrimSpace(n))
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// pickVaults returns the vaults picked by spec, the value of --vault, or
+// the default config without one.
+func pickVaults(spec string) []string {
+	if spec == "" {
+		return []string{""}
+	}
+	names, err := parseVaults(spec)
 	if err != nil {
-		return fmt.Errorf("loading config: %v", err)
+		log.Fatalf("Parsing --vault: %v", err)
 	}
+	return names
+}
 
-	urls, err := gauth.ParseConfig(raw)
+// parseTime returns the time of --time, in RFC 3339 or Unix seconds.
+func parseTime(s string) time.Time {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0)
+	}
+	t, err := time.Parse(time.RFC3339, s)
 	if err != nil {
-		return fmt.Errorf("parsing config: %v", err)
+		log.Fatalf("Invalid --time %q: want RFC 3339, such as 2006-01-02T15:04:05Z, or Unix seconds", s)
 	}
+	return t
+}
 
-	cachedRaw = raw
-	cachedUrls = urls
-	return nil
+// now returns the time to show codes at.
+func now() time.Time {
+	if codeTime.IsZero() {
+		return time.Now()
+	}
+	return codeTime
 }
 
-func getUrls() []*otpauth.URL {
-	if err := loadConfig(); err != nil {
+// lockConfig locks the config for a read-modify-write cycle, which must
+// start after the lock is taken. If gauth exits without unlocking, the
+// operating system releases the lock.
+func lockConfig() *gauth.Lock {
+	return lockVault(currentVault())
+}
+
+// lockVault locks the vault named name, as lockConfig does.
+func lockVault(name string) *gauth.Lock {
+	timeout := defaultLockTimeout
+	if t := os.Getenv("GAUTH_LOCK_TIMEOUT"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			log.Fatalf("Invalid GAUTH_LOCK_TIMEOUT %q: %v", t, err)
+		}
+		timeout = d
+	}
+	cfgPath := vaultPath(name)
+	if err := os.MkdirAll(filepath.Dir(cfgPath), 0700); err != nil {
+		log.Fatalf("Creating config directory: %v", err)
+	}
+	lock, err := gauth.LockConfigFile(cfgPath, timeout)
+	if err != nil {
 		log.Fatal(err)
 	}
-	return cachedUrls
+	return lock
 }
 
-func getRawConfig() []byte {
-	if err := loadConfig(); err != nil {
-		log.Fatal(err)
+func getVault() *gauth.Vault {
+	return loadVault(currentVault())
+}
+
+// loadVault returns the vault named name, asking for its password once.
+func loadVault(name string) *gauth.Vault {
+	if vault, ok := cachedVaults[name]; ok {
+		return vault
+	}
+	vault, err := gauth.LoadVault(vaultPath(name), func() ([]byte, error) { return readPassword(passwordPrompt(name)) })
+	if err != nil {
+		if name == "" {
+			log.Fatalf("Loading config: %v", err)
+		}
+		log.Fatalf("Loading vault %s: %v", name, err)
 	}
-	return cachedRaw
+	cachedVaults[name] = vault
+	return vault
 }
 
-func printBareCode(accountName string, urls []*otpauth.URL) {
-	for _, url := range urls {
-		if matchAccount(accountName, url.Account) {
-			_, curr, _, err := gauth.Codes(url)
-			if err != nil {
-				log.Fatalf("Generating codes for %q: %v", url.Account, err)
-			}
-			fmt.Print(curr)
-			return
+// getUrls returns the accounts of the vaults picked with --vault.
+func getUrls() []*otpauth.URL {
+	var urls []*otpauth.URL
+	for _, name := range vaultNames {
+		urls = append(urls, loadVault(name).URLs()...)
+	}
+	return urls
+}
+
+// vaultOf returns the name of the loaded vault holding u, and the vault.
+func vaultOf(u *otpauth.URL) (string, *gauth.Vault) {
+	for _, name := range vaultNames {
+		if vault, ok := cachedVaults[name]; ok && slices.Contains(vault.URLs(), u) {
+			return name, vault
 		}
 	}
+	return "", nil
+}
+
+// accountTags returns the tags of u in its vault.
+func accountTags(u *otpauth.URL) []string {
+	if _, vault := vaultOf(u); vault != nil {
+		return vault.Tags(u)
+	}
+	return nil
+}
+
+func saveVault(vault *gauth.Vault) {
+	if err := vault.Save(); err != nil {
+		log.Fatalf("Error writing config: %v", err)
+	}
+}
+
+func printBareCode(accountName string, urls []*otpauth.URL) {
+	url := selectAccount(accountName, urls)
+	if url.Type == "hotp" {
+		log.Fatalf("%q is an HOTP account, use gauth next to get its next code", url.Account)
+	}
+	if outputFormat == "json" {
+		printJSON(newCodeEntry(url))
+		return
+	}
+	_, curr, _, err := gauth.CodesAt(url, now())
+	if err != nil {
+		log.Fatalf("Generating codes for %q: %v", url.Account, err)
+	}
+	fmt.Print(curr)
+}
+
+func printNextCode(accountName string, urls []*otpauth.URL) {
+	url := selectAccount(accountName, urls)
+	code, err := gauth.NextHOTP(url)
+	if err != nil {
+		log.Fatalf("Generating code for %q: %v", url.Account, err)
+	}
+	// Persist the new counter first, so a code is never shown twice.
+	vault := getVault()
+	if err := vault.Update(url); err != nil {
+		log.Fatalf("Updating counter: %v", err)
+	}
+	saveVault(vault)
+	if outputFormat == "json" {
+		entry := newCodeEntry(url)
+		entry.Curr = code
+		printJSON(entry)
+		return
+	}
+	fmt.Print(code)
 }
 
 func printSecret(accountName string, urls []*otpauth.URL) {
-	for _, url := range urls {
-		if matchAccount(accountName, url.Account) {
-			fmt.Print(url.RawSecret)
-			return
+	fmt.Print(selectAccount(accountName, urls).RawSecret)
+}
+
+func printURL(accountName string, urls []*otpauth.URL) {
+	text := selectAccount(accountName, urls).String()
+	code, err := qr.Encode([]byte(text), qr.M)
+	if err != nil {
+		log.Fatalf("Encoding QR code: %v", err)
+	}
+	drawing := renderQR(code, false)
+	fmt.Println(text)
+	fmt.Print(drawing)
+}
+
+// renderQR draws code with the graphics protocol named by GAUTH_GRAPHICS, or
+// else one the terminal is known to support, or else as text.
+func renderQR(code *qr.Code, invert bool) string {
+	graphics := os.Getenv("GAUTH_GRAPHICS")
+	if graphics == "" && term.IsTerminal(int(os.Stdout.Fd())) {
+		switch {
+		case os.Getenv("KITTY_WINDOW_ID") != "" || os.Getenv("TERM") == "xterm-kitty" ||
+			os.Getenv("TERM_PROGRAM") == "ghostty":
+			graphics = "kitty"
+		case os.Getenv("TERM") == "mlterm" || strings.HasPrefix(os.Getenv("TERM"), "foot") ||
+			os.Getenv("TERM_PROGRAM") == "WezTerm":
+			graphics = "sixel"
 		}
 	}
+	switch graphics {
+	case "kitty":
+		return code.Kitty(8)
+	case "sixel":
+		return code.Sixel(4) + "\n"
+	case "", "text":
+		return code.Terminal(invert)
+	default:
+		log.Fatalf("Invalid GAUTH_GRAPHICS %q: want kitty, sixel or text", graphics)
+		return ""
+	}
 }
 
 func addCode(accountName string) {
-	cfgPath := getConfigPath()
-	if err := os.MkdirAll(filepath.Dir(cfgPath), 0700); err != nil {
-		log.Fatalf("Creating config directory: %v", err)
+	url := &otpauth.URL{Type: "totp", Account: accountName}
+	if issuer, account, ok := strings.Cut(accountName, "/"); ok && issuer != "" && account != "" {
+		// As in the name:secret form of the config.
+		url.Issuer, url.Account = issuer, account
+	}
+	vault := getVault()
+	for _, u := range vault.URLs() {
+		if u.Issuer == url.Issuer && u.Account == url.Account {
+			fmt.Printf("Account %q already exists. Nothing added.\n", accountName)
+			return
+		}
 	}
 
-	password, err := handleEncryption(cfgPath)
-	if err != nil && !os.IsNotExist(err) {
-		log.Fatalf("Handling encryption: %v", err)
+	url.RawSecret = readNewKey(accountName)
+	if err := vault.Add(url); err != nil {
+		log.Fatalf("Adding account: %v", err)
 	}
+	fmt.Printf("Current OTP for %s: ", accountName)
+	printBareCode(accountName, []*otpauth.URL{url})
+	saveVault(vault)
+}
 
-	var rawConfig []byte
-	if _, statErr := os.Stat(cfgPath); os.IsNotExist(statErr) {
-		rawConfig = []byte("")
-	} else {
-		rawConfig = getRawConfig()
-		if accountExists(accountName, rawConfig) {
-			fmt.Printf("Account %q already exists. Nothing added.\n", accountName)
-			return
+// addAccounts adds the account its argument names, or those of the QR code
+// image given with --qr.
+func addAccounts(args []string) {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	path := flags.String("qr", "", "read accounts from the QR code in `IMAGE`")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gauth add ACCOUNT")
+		fmt.Fprintln(flags.Output(), "       gauth add --qr IMAGE")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // exits on error
+	switch {
+	case *path == "" && flags.NArg() == 1:
+		addCode(flags.Arg(0))
+	case *path == "" || flags.NArg() > 0:
+		flags.Usage()
+		os.Exit(2)
+	default:
+		addFromImage(*path)
+	}
+}
+
+func addFromImage(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Opening image: %v", err)
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Decoding %s: %v", path, err)
+	}
+	data, err := qr.Decode(img)
+	if err != nil {
+		log.Fatalf("Reading QR code in %s: %v", path, err)
+	}
+	urls, err := gauth.ParseURLs(string(data))
+	if err != nil {
+		log.Fatalf("Parsing QR code in %s: %v", path, err)
+	}
+
+	vault := getVault()
+	added := 0
+	for _, url := range urls {
+		if err := vault.Add(url); err != nil {
+			fmt.Printf("Skipped %s: %v\n", displayName(url), err)
+			continue
 		}
+		added++
+		if url.Type == "hotp" {
+			fmt.Printf("Added %s, run gauth next %s for its first code\n", displayName(url), url.Account)
+			continue
+		}
+		_, curr, _, err := gauth.CodesAt(url, now())
+		if err != nil {
+			log.Fatalf("Generating codes for %q: %v", url.Account, err)
+		}
+		fmt.Printf("Added %s, current OTP: %s\n", displayName(url), curr)
 	}
+	if added > 0 {
+		saveVault(vault)
+	}
+}
+
+// removeCode removes the accounts picked by selector, without falling back
+// to partial matches, after listing them and asking for confirmation.
+func removeCode(selector string) {
+	vault := getVault()
+	matches := selectAccounts(selector, vault.URLs(), false)
+	if len(matches) == 0 {
+		fmt.Printf("No account matches %q. Nothing removed.\n", selector)
+		return
+	}
+	if !confirmRemoval(matches) {
+		return
+	}
+	for _, url := range matches {
+		if err := vault.Remove(url); err != nil {
+			log.Fatalf("Removing account: %v", err)
+		}
+	}
+	saveVault(vault)
+	for _, url := range matches {
+		fmt.Printf("%s has been removed.\n", displayName(url))
+	}
+}
+
+// confirmRemoval asks whether to remove urls, listing them if there are
+// several.
+func confirmRemoval(urls []*otpauth.URL) bool {
+	if len(urls) == 1 {
+		return confirm(fmt.Sprintf("Are you sure you want to remove %s", displayName(urls[0])))
+	}
+	fmt.Println("This will remove:")
+	for _, url := range urls {
+		fmt.Printf("  %s\n", displayName(url))
+	}
+	return confirm(fmt.Sprintf("Are you sure you want to remove these %d accounts", len(urls)))
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	reader := bufio.NewReader(os.Stdin)
+	resp, _ := reader.ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(resp)) == "y"
+}
 
-	key := readNewKey(accountName)
-	newConfig := updateConfig(string(rawConfig), accountName, key)
-	if err := validateAndSaveConfig(cfgPath, password, newConfig, accountName); err != nil {
-		log.Fatalf("Saving config: %v", err)
+// confirmOnTerminal is like confirm, but reads the answer from the terminal
+// when standard input is not one, as it may carry data, e.g. for import.
+func confirmOnTerminal(question string) bool {
+	if term.IsTerminal(int(syscall.Stdin)) {
+		return confirm(question)
 	}
-	cachedRaw = nil
-	cachedUrls = nil
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		log.Fatalf("Cannot ask for confirmation without a terminal, pass -y to skip it: %v", err)
+	}
+	defer tty.Close()
+	fmt.Printf("%s [y/N]: ", question)
+	resp, _ := bufio.NewReader(tty).ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(resp)) == "y"
 }
 
-func removeCode(accountName string) {
+func migrateConfig() {
 	cfgPath := getConfigPath()
-	password, err := handleEncryption(cfgPath)
+	data, isEncrypted, err := gauth.ReadConfigFile(cfgPath)
 	if err != nil {
 		log.Fatalf("Reading config: %v", err)
 	}
-	rawConfig := getRawConfig()
-	newConfig, removed := buildNewConfig(accountName, rawConfig)
-	if !removed {
-		fmt.Printf("Account %q not found. Nothing removed.\n", accountName)
+	if gauth.IsNative(data) {
+		fmt.Printf("%s is already in the native vault format.\n", cfgPath)
 		return
 	}
-	if !confirmRemoval(accountName) {
+	if !isEncrypted {
+		log.Fatalf("%s is not encrypted, so there is nothing to migrate", cfgPath)
+	}
+	password, err := getPassword()
+	if err != nil {
+		log.Fatalf("Reading passphrase: %v", err)
+	}
+	if err := gauth.MigrateConfigFile(cfgPath, password); err != nil {
+		log.Fatalf("Migrating config: %v", err)
+	}
+	fmt.Printf("%s has been converted to the native vault format.\n", cfgPath)
+}
+
+func encryptConfig() {
+	cfgPath := getConfigPath()
+	if _, isEncrypted, err := gauth.ReadConfigFile(cfgPath); err != nil {
+		log.Fatalf("Reading config: %v", err)
+	} else if isEncrypted {
+		log.Fatalf("%s is already encrypted, use passwd to change its password", cfgPath)
+	}
+	if err := gauth.EncryptConfigFile(cfgPath, getNewPassword()); err != nil {
+		log.Fatalf("Encrypting config: %v", err)
+	}
+	fmt.Printf("%s has been encrypted.\n", cfgPath)
+}
+
+func decryptConfig() {
+	cfgPath := getConfigPath()
+	if _, isEncrypted, err := gauth.ReadConfigFile(cfgPath); err != nil {
+		log.Fatalf("Reading config: %v", err)
+	} else if !isEncrypted {
+		log.Fatalf("%s is not encrypted", cfgPath)
+	}
+	password, err := getPassword()
+	if err != nil {
+		log.Fatalf("Reading passphrase: %v", err)
+	}
+	if err := gauth.DecryptConfigFile(cfgPath, password); err != nil {
+		log.Fatalf("Decrypting config: %v", err)
+	}
+	fmt.Printf("%s has been decrypted and now holds your secrets in plaintext.\n", cfgPath)
+}
+
+func changePassword() {
+	cfgPath := getConfigPath()
+	if _, isEncrypted, err := gauth.ReadConfigFile(cfgPath); err != nil {
+		log.Fatalf("Reading config: %v", err)
+	} else if !isEncrypted {
+		log.Fatalf("%s is not encrypted, use encrypt to set a password", cfgPath)
+	}
+	password, err := getPassword()
+	if err != nil {
+		log.Fatalf("Reading passphrase: %v", err)
+	}
+	if err := gauth.ChangePassword(cfgPath, password, getNewPassword()); err != nil {
+		log.Fatalf("Changing password: %v", err)
+	}
+	fmt.Printf("The password of %s has been changed.\n", cfgPath)
+}
+
+func restoreBackup(args []string) {
+	cfgPath := getConfigPath()
+	backups, err := gauth.ListBackups(cfgPath)
+	if err != nil {
+		log.Fatalf("Listing backups: %v", err)
+	}
+	if len(args) == 0 {
+		if len(backups) == 0 {
+			fmt.Printf("No backups found for %s\n", cfgPath)
+			return
+		}
+		fmt.Printf("Backups of %s, most recent first:\n", cfgPath)
+		for _, b := range backups {
+			fmt.Printf("  %d  %s  %s\n", b.Index, b.ModTime.Format("2006-01-02 15:04:05"), b.Path)
+		}
+		fmt.Println("\nRun gauth restore N to roll back to backup N.")
 		return
 	}
-	if err := gauth.WriteConfigFile(cfgPath, password, []byte(newConfig)); err != nil {
-		log.Fatalf("Error writing config: %v", err)
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Fatalf("Invalid backup number %q", args[0])
 	}
-	cachedRaw = nil
-	cachedUrls = nil
-	fmt.Printf("%s has been removed.\n", accountName)
+	for _, b := range backups {
+		if b.Index != n {
+			continue
+		}
+		if !confirm(fmt.Sprintf("Replace %s with the backup from %s", cfgPath, b.ModTime.Format("2006-01-02 15:04:05"))) {
+			return
+		}
+		if err := gauth.RestoreBackup(cfgPath, n); err != nil {
+			log.Fatalf("Restoring backup: %v", err)
+		}
+		fmt.Printf("%s has been restored from backup %d; its previous contents are now backup 1.\n", cfgPath, n)
+		return
+	}
+	log.Fatalf("Backup %d not found, run gauth restore to list backups", n)
 }
 
-func buildNewConfig(accountName string, rawConfig []byte) (string, bool) {
-	var builder strings.Builder
-	removed := false
-	for _, line := range strings.Split(string(rawConfig), "\n") {
-		trim := strings.TrimSpace(line)
-		if trim == "" {
+// tagAccounts adds tags to the accounts picked by the selector in args[0],
+// or removes them if remove is set, and lists the accounts it changed.
+func tagAccounts(args []string, remove bool) {
+	if len(args) < 2 {
+		if remove {
+			log.Fatal("Usage: gauth remove-tag ACCOUNT TAG...")
+		}
+		log.Fatal("Usage: gauth add-tag ACCOUNT TAG...")
+	}
+	vault := getVault()
+	urls := selectAccounts(args[0], vault.URLs(), false)
+	if len(urls) == 0 {
+		log.Fatalf("No account matches %q", args[0])
+	}
+	changed := 0
+	for _, url := range urls {
+		tags := vault.Tags(url)
+		for _, tag := range args[1:] {
+			has := slices.IndexFunc(tags, func(t string) bool { return strings.EqualFold(t, tag) })
+			switch {
+			case remove && has >= 0:
+				tags = slices.Delete(tags, has, has+1)
+			case !remove && has < 0:
+				tags = append(tags, tag)
+			}
+		}
+		if slices.Equal(tags, vault.Tags(url)) {
 			continue
 		}
-		parts := strings.SplitN(trim, ":", 2)
-		if len(parts) > 0 {
-			accName := strings.TrimSpace(parts[0])
-			if matchAccount(accountName, accName) {
-				removed = true
-				continue
+		if err := vault.SetTags(url, tags); err != nil {
+			log.Fatalf("Tagging %s: %v", displayName(url), err)
+		}
+		changed++
+		if len(tags) == 0 {
+			fmt.Printf("%s has no tags\n", displayName(url))
+		} else {
+			fmt.Printf("%s is tagged %s\n", displayName(url), strings.Join(tags, ", "))
+		}
+	}
+	if changed == 0 {
+		fmt.Println("Nothing changed.")
+		return
+	}
+	saveVault(vault)
+}
+
+// transferAccounts copies the accounts picked by the selector in args[0],
+// with their comments, to the vault named args[1], and removes them from the
+// current vault if move is set. Accounts the other vault already has are
+// left where they are.
+func transferAccounts(args []string, move bool) {
+	verb, done := "copy", "Copied"
+	if move {
+		verb, done = "move", "Moved"
+	}
+	flags := flag.NewFlagSet(verb, flag.ExitOnError)
+	create := flags.Bool("create", false, "create VAULT if it does not exist, encrypted with a new password if the accounts come from an encrypted vault")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gauth %s [-create] ACCOUNT VAULT\n", verb)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // exits on error
+	args = flags.Args()
+	if len(args) != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	dest, err := parseVaultName(args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	source := getVault()
+	if dest == currentVault() || vaultPath(dest) == getConfigPath() {
+		log.Fatalf("The accounts are already in vault %s", vaultDisplayName(dest))
+	}
+	urls := selectAccounts(args[0], source.URLs(), false)
+	if len(urls) == 0 {
+		log.Fatalf("No account matches %q", args[0])
+	}
+
+	exists := func() bool {
+		_, err := os.Stat(vaultPath(dest))
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Reading vault %s: %v", vaultDisplayName(dest), err)
+		}
+		return err == nil
+	}
+	if !*create && !exists() {
+		log.Fatalf("Vault %s does not exist, use %s -create to create it", vaultDisplayName(dest), verb)
+	}
+	defer lockVault(dest).Unlock()
+	var target *gauth.Vault
+	if exists() {
+		target = loadVault(dest)
+	} else {
+		// Secrets from an encrypted vault must not land in a plaintext one.
+		var passwd []byte
+		if source.Encrypted() {
+			passwd = readNewPassword(fmt.Sprintf("password of vault %s", vaultDisplayName(dest)))
+		}
+		target = gauth.NewVault(vaultPath(dest), passwd)
+	}
+	if err := checkTransfer(source, target, dest); err != nil {
+		log.Fatalf("Cannot %s accounts of an encrypted vault: %v", verb, err)
+	}
+	var accounts []gauth.Account
+	originals := make(map[*otpauth.URL]*otpauth.URL)
+	for _, url := range urls {
+		// The other vault gets its own copy.
+		c := *url
+		accounts = append(accounts, gauth.Account{URL: &c, Comment: source.Comment(url)})
+		originals[&c] = url
+	}
+	report := target.ImportAccounts(accounts)
+	name := vaultDisplayName(dest)
+	for _, url := range report.Added {
+		fmt.Printf("%s %s to %s\n", done, displayName(url), name)
+	}
+	for _, url := range report.Duplicates {
+		fmt.Printf("Skipped %s: its secret is already in %s\n", displayName(url), name)
+	}
+	for _, url := range report.Collisions {
+		fmt.Printf("Skipped %s: %s has another account with this name\n", displayName(url), name)
+	}
+	for _, f := range report.Failed {
+		fmt.Printf("Skipped %s: %v\n", displayName(f.URL), f.Err)
+	}
+	if len(report.Added) == 0 {
+		return
+	}
+
+	// Save the copies first, so that a failure cannot lose accounts.
+	saveVault(target)
+	if move {
+		for _, url := range report.Added {
+			if err := source.Remove(originals[url]); err != nil {
+				log.Fatalf("Removing account: %v", err)
 			}
 		}
-		builder.WriteString(trim)
-		builder.WriteByte('\n')
+		saveVault(source)
 	}
-	return builder.String(), removed
 }
 
-func confirmRemoval(accountName string) bool {
-	fmt.Printf("Are you sure you want to remove %s [y/N]: ", accountName)
-	reader := bufio.NewReader(os.Stdin)
-	resp, _ := reader.ReadString('\n')
-	return strings.ToLower(strings.TrimSpace(resp)) == "y"
+// checkTransfer returns an error if accounts of source must not go to target,
+// the vault named dest: secrets from an encrypted vault must not be written
+// to a plaintext one.
+func checkTransfer(source, target *gauth.Vault, dest string) error {
+	if source.Encrypted() && !target.Encrypted() {
+		name := vaultDisplayName(dest)
+		return fmt.Errorf("vault %s is not encrypted, encrypt it first with gauth --vault %s encrypt", name, name)
+	}
+	return nil
 }
 
-func updateConfig(currentConfig, accountName, key string) string {
-	var builder strings.Builder
-	builder.WriteString(strings.TrimSuffix(currentConfig, "\n"))
-	builder.WriteByte('\n')
-	builder.WriteString(accountName)
-	builder.WriteByte(':')
-	builder.WriteString(key)
-	builder.WriteByte('\n')
-	return builder.String()
+func importAccounts(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	yes := flags.Bool("y", false, "add the accounts without showing a preview and asking first")
+	name := flags.String("name", "", "name the imported account `NAME`, e.g. for PAM files, which do not name theirs")
+	format := flags.String("format", "", "read the inputs as `FORMAT` instead of recognizing it: andotp for encrypted andOTP backups not named .json.aes")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gauth import [-y] [-name NAME] [-format andotp] [URL|FILE|-]...")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // exits on error
+	if *format != "" && *format != "andotp" {
+		log.Fatalf("Unknown import format %q", *format)
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	var accounts []gauth.Account
+	var failed []gauth.ImportFailure
+	for _, arg := range args {
+		data := []byte(arg)
+		if arg == "-" {
+			var err error
+			if data, err = io.ReadAll(os.Stdin); err != nil {
+				log.Fatalf("Reading standard input: %v", err)
+			}
+		} else if !strings.Contains(arg, "://") {
+			var err error
+			if data, err = os.ReadFile(arg); err != nil {
+				log.Fatalf("Reading %s: %v", arg, err)
+			}
+		}
+		getPass := func() ([]byte, error) {
+			return readPassword(fmt.Sprintf("Password of %s: ", importSource(arg)))
+		}
+		var parsed []gauth.Account
+		var parseFailed []gauth.ImportFailure
+		var err error
+		if *format == "andotp" || strings.HasSuffix(arg, ".json.aes") {
+			// Encrypted andOTP backups cannot be recognized by their content.
+			parsed, parseFailed, err = gauth.ParseAndOTP(data, getPass)
+		} else if gauth.IsSQLite(data) {
+			// Changes may still be in the write-ahead log next to the database.
+			var wal []byte
+			if arg != "-" {
+				var walErr error
+				if wal, walErr = os.ReadFile(arg + "-wal"); walErr != nil && !os.IsNotExist(walErr) {
+					log.Fatalf("Reading %s-wal: %v", arg, walErr)
+				}
+			}
+			if wal == nil && gauth.IsSQLiteWAL(data) {
+				fmt.Fprintf(os.Stderr, "Warning: %s is in write-ahead log mode and was read without its -wal file; its latest changes may be missing\n", importSource(arg))
+			}
+			parsed, parseFailed, err = gauth.ParseGoogleAuthenticatorDB(data, wal)
+		} else {
+			parsed, parseFailed, err = gauth.ParseExport(data, getPass)
+		}
+		if err != nil {
+			log.Fatalf("Parsing %s: %v", importSource(arg), err)
+		}
+		accounts = append(accounts, parsed...)
+		failed = append(failed, parseFailed...)
+	}
+	if *name != "" {
+		if len(accounts) != 1 {
+			log.Fatalf("Found %d accounts to import, -name needs exactly one", len(accounts))
+		}
+		accounts[0].URL.Issuer, accounts[0].URL.Account = "", *name
+	}
+
+	vault := getVault()
+	report := vault.ImportAccounts(accounts)
+	report.Failed = append(failed, report.Failed...)
+	printImportReport(report, !*yes)
+	if len(report.Added) == 0 {
+		return
+	}
+	if !*yes && !confirmOnTerminal(fmt.Sprintf("Add %d accounts to %s", len(report.Added), getConfigPath())) {
+		fmt.Println("Nothing was imported.")
+		return
+	}
+	saveVault(vault)
 }
 
-func validateAndSaveConfig(cfgPath string, password []byte, newConfig, accountName string) error {
-	parsedCfg, err := gauth.ParseConfig([]byte(newConfig))
-	if err != nil {
-		return fmt.Errorf("parsing new config: %v", err)
+func importSource(arg string) string {
+	switch {
+	case arg == "-":
+		return "standard input"
+	case strings.Contains(arg, "://"):
+		return "URL"
+	default:
+		return arg
 	}
-	fmt.Printf("Current OTP for %s: ", accountName)
-	printBareCode(accountName, parsedCfg)
-	return gauth.WriteConfigFile(cfgPath, password, []byte(newConfig))
 }
 
-func accountExists(accountName string, rawConfig []byte) bool {
-	for _, line := range strings.Split(string(rawConfig), "\n") {
-		trim := strings.TrimSpace(line)
-		if trim == "" {
+// printImportReport prints what an import did, or will do once confirmed if
+// preview is set.
+func printImportReport(report gauth.ImportReport, preview bool) {
+	added, skipped := "Added", "Skipped"
+	if preview {
+		added, skipped = "Will add", "Will skip"
+	}
+	for _, url := range report.Added {
+		fmt.Printf("%s %s\n", added, displayName(url))
+	}
+	for _, url := range report.Duplicates {
+		fmt.Printf("%s %s: its secret is already in the config\n", skipped, displayName(url))
+	}
+	for _, url := range report.Collisions {
+		fmt.Printf("%s %s: another account already has this name\n", skipped, displayName(url))
+	}
+	for _, f := range report.Failed {
+		fmt.Printf("%s %s: %v\n", skipped, displayName(f.URL), f.Err)
+	}
+	summary := "%d added, %d skipped.\n"
+	if preview {
+		summary = "%d to add, %d to skip.\n"
+	}
+	fmt.Printf(summary, len(report.Added), len(report.Duplicates)+len(report.Collisions)+len(report.Failed))
+}
+
+func exportAccounts(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "qr", "export `FORMAT`: qr for Google Authenticator, aegis, 2fas, keepassxc for a CSV file, or pam for a ~/.google_authenticator file")
+	output := flags.String("o", "", "write the export to `FILE`, for formats other than qr")
+	encrypt := flags.Bool("encrypt", false, "encrypt the export with a new password, for aegis")
+	batch := flags.Int("batch", 3, "accounts per QR code")
+	prefix := flags.String("png", "", "write PNG images named `PREFIX`-N.png instead of printing QR codes")
+	invert := flags.Bool("invert", false, "draw QR codes for terminals with dark text on a light background")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gauth export [-batch N] [-png PREFIX] [-invert] [account]...")
+		fmt.Fprintln(flags.Output(), "       gauth export -format aegis -o FILE [-encrypt] [account]...")
+		fmt.Fprintln(flags.Output(), "       gauth export -format 2fas -o FILE [account]...")
+		fmt.Fprintln(flags.Output(), "       gauth export -format keepassxc -o FILE [account]...")
+		fmt.Fprintln(flags.Output(), "       gauth export -format pam -o FILE account")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // exits on error
+	if !slices.Contains([]string{"qr", "aegis", "2fas", "keepassxc", "pam"}, *format) {
+		log.Fatalf("Unknown export format %q", *format)
+	}
+
+	var accounts []gauth.Account
+	all := getVault().Accounts()
+	picked := make(map[*otpauth.URL]bool)
+	var urls []*otpauth.URL
+	for _, a := range all {
+		urls = append(urls, a.URL)
+	}
+	for _, selector := range flags.Args() {
+		selected := selectAccounts(selector, urls, true)
+		if len(selected) == 0 {
+			log.Fatalf("No account matches %q", selector)
+		}
+		for _, url := range selected {
+			picked[url] = true
+		}
+	}
+	for _, a := range all {
+		if flags.NArg() == 0 || picked[a.URL] {
+			accounts = append(accounts, a)
+		}
+	}
+	if len(accounts) == 0 {
+		log.Fatal("No accounts to export")
+	}
+
+	switch *format {
+	case "qr":
+		if *output != "" || *encrypt {
+			log.Fatal("QR codes cannot be encrypted or written with -o, use -png")
+		}
+		exportQR(accounts, *batch, *prefix, *invert)
+	case "aegis":
+		if *output == "" {
+			log.Fatalf("Exporting to %s needs -o FILE", *format)
+		}
+		var passwd []byte
+		if *encrypt {
+			passwd = readNewPassword("Aegis password")
+		}
+		data, err := gauth.ExportAegis(accounts, passwd)
+		if err != nil {
+			log.Fatalf("Exporting accounts: %v", err)
+		}
+		writePrivateFile(*output, data)
+		fmt.Printf("Wrote %d accounts to %s\n", len(accounts), *output)
+	case "2fas":
+		if *output == "" {
+			log.Fatalf("Exporting to %s needs -o FILE", *format)
+		}
+		if *encrypt {
+			log.Fatal("gauth cannot encrypt 2FAS backups, protect the file or import it into 2FAS and delete it")
+		}
+		data, err := gauth.Export2FAS(accounts)
+		if err != nil {
+			log.Fatalf("Exporting accounts: %v", err)
+		}
+		writePrivateFile(*output, data)
+		fmt.Printf("Wrote %d accounts to %s\n", len(accounts), *output)
+	case "keepassxc":
+		if *output == "" {
+			log.Fatalf("Exporting to %s needs -o FILE", *format)
+		}
+		if *encrypt {
+			log.Fatal("KeePassXC CSV files cannot be encrypted")
+		}
+		var supported []gauth.Account
+		for _, a := range accounts {
+			if err := gauth.CheckKeePassXC(a.URL); err != nil {
+				fmt.Fprintf(os.Stderr, "Skipped %s: %v\n", displayName(a.URL), err)
+				continue
+			}
+			supported = append(supported, a)
+		}
+		if len(supported) == 0 {
+			log.Fatal("No accounts to export")
+		}
+		data, err := gauth.ExportKeePassXCCSV(supported)
+		if err != nil {
+			log.Fatalf("Exporting accounts: %v", err)
+		}
+		writePrivateFile(*output, data)
+		fmt.Printf("Wrote %d accounts to %s\n", len(supported), *output)
+	case "pam":
+		if *output == "" {
+			log.Fatalf("Exporting to %s needs -o FILE", *format)
+		}
+		if *encrypt {
+			log.Fatal("PAM files cannot be encrypted")
+		}
+		if flags.NArg() != 1 {
+			log.Fatal("PAM files hold a single account, name it")
+		}
+		if len(accounts) != 1 {
+			log.Fatalf("%s matches %d accounts, PAM files hold a single one", flags.Arg(0), len(accounts))
+		}
+		data, err := gauth.ExportPAM(accounts[0])
+		if err != nil {
+			log.Fatalf("Exporting %s: %v", displayName(accounts[0].URL), err)
+		}
+		writePrivateFile(*output, data)
+		fmt.Printf("Wrote %s to %s\n", displayName(accounts[0].URL), *output)
+	}
+}
+
+// exportQR shows accounts as Google Authenticator migration QR codes of
+// batch accounts each, or writes them to PNG files named after prefix.
+func exportQR(accounts []gauth.Account, batch int, prefix string, invert bool) {
+	if batch < 1 {
+		log.Fatalf("Invalid batch size %d", batch)
+	}
+	var urls []*otpauth.URL
+	for _, a := range accounts {
+		if err := gauth.CheckMigration(a.URL); err != nil {
+			fmt.Fprintf(os.Stderr, "Skipped %s: %v\n", displayName(a.URL), err)
 			continue
 		}
-		parts := strings.SplitN(trim, ":", 2)
-		if len(parts) < 2 {
+		urls = append(urls, a.URL)
+	}
+	if len(urls) == 0 {
+		log.Fatal("No accounts to export")
+	}
+
+	migrations, err := gauth.MigrationURLs(urls, batch)
+	if err != nil {
+		log.Fatalf("Encoding accounts: %v", err)
+	}
+	for i, m := range migrations {
+		code, err := qr.Encode([]byte(m), qr.L)
+		if err != nil {
+			log.Fatalf("Encoding QR code: %v", err)
+		}
+		var names []string
+		for _, url := range urls[i*batch : min((i+1)*batch, len(urls))] {
+			names = append(names, displayName(url))
+		}
+		if prefix != "" {
+			path := fmt.Sprintf("%s-%d.png", prefix, i+1)
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, code.Image(8)); err != nil {
+				log.Fatalf("Encoding %s: %v", path, err)
+			}
+			writePrivateFile(path, buf.Bytes())
+			fmt.Printf("Wrote %s: %s\n", path, strings.Join(names, ", "))
 			continue
 		}
-		if matchAccount(accountName, strings.TrimSpace(parts[0])) {
-			return true
+		fmt.Printf("Code %d of %d: %s\n", i+1, len(migrations), strings.Join(names, ", "))
+		fmt.Print(renderQR(code, invert))
+	}
+}
+
+// writePrivateFile writes data to path, only readable by the user since it
+// holds secrets.
+func writePrivateFile(path string, data []byte) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Fatalf("Creating %s: %v", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		log.Fatalf("Writing %s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Writing %s: %v", path, err)
+	}
+}
+
+// displayName returns the name of url, prefixed by its issuer if any.
+func displayName(url *otpauth.URL) string {
+	if url.Issuer != "" {
+		return url.Issuer + ":" + url.Account
+	}
+	return url.Account
+}
+
+// A grouping is how printCodes lists accounts.
+type grouping int
+
+const (
+	noGrouping grouping = iota
+	byIssuer
+	byTag
+)
+
+// listCodes shows the codes of all accounts, or of those its argument
+// selects.
+func listCodes(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	issuers := flags.Bool("by-issuer", false, "list accounts under their issuers")
+	tags := flags.Bool("by-tag", false, "list accounts under their tags")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gauth list [-by-issuer|-by-tag] [ACCOUNT]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // exits on error
+	if flags.NArg() > 1 || *issuers && *tags {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	urls := getUrls()
+	if filter := flags.Arg(0); filter != "" {
+		urls = selectAccounts(filter, urls, true)
+	}
+	if outputFormat == "json" {
+		entries := []codeEntry{}
+		for _, url := range urls {
+			entries = append(entries, newCodeEntry(url))
 		}
+		printJSON(entries)
+		return
+	}
+	by := noGrouping
+	switch {
+	case *issuers:
+		by = byIssuer
+	case *tags:
+		by = byTag
 	}
-	return false
+	printCodes(urls, by)
+}
+
+// A codeEntry is an account and its codes, as --format json shows them.
+type codeEntry struct {
+	Vault     string   `json:"vault,omitempty"`
+	Issuer    string   `json:"issuer,omitempty"`
+	Account   string   `json:"account"`
+	Type      string   `json:"type"`
+	Tags      []string `json:"tags,omitempty"`
+	Prev      string   `json:"prev,omitempty"`
+	Curr      string   `json:"curr,omitempty"`
+	Next      string   `json:"next,omitempty"`
+	Remaining int      `json:"remaining,omitempty"` // seconds until curr expires
+	Counter   *uint64  `json:"counter,omitempty"`   // that of the next HOTP code
 }
 
-func handleEncryption(cfgPath string) ([]byte, error) {
-	_, isEncrypted, err := gauth.ReadConfigFile(cfgPath)
-	if err != nil && !os.IsNotExist(err) {
-		return nil, err
+// newCodeEntry returns the entry of url, with its codes unless it is an HOTP
+// account, whose codes advance its counter.
+func newCodeEntry(url *otpauth.URL) codeEntry {
+	entry := codeEntry{Issuer: url.Issuer, Account: url.Account, Type: url.Type, Tags: accountTags(url)}
+	if len(vaultNames) > 1 {
+		vault, _ := vaultOf(url)
+		entry.Vault = vaultDisplayName(vault)
 	}
-	if !isEncrypted {
-		return nil, nil
+	if url.Type == "hotp" {
+		counter := url.Counter
+		entry.Counter = &counter
+		return entry
 	}
-	pass, err := getPassword()
+	var err error
+	entry.Prev, entry.Curr, entry.Next, err = gauth.CodesAt(url, now())
 	if err != nil {
-		return nil, fmt.Errorf("reading passphrase: %v", err)
+		log.Fatalf("Generating codes for %q: %v", url.Account, err)
 	}
-	return pass, nil
+	period := codePeriod(url)
+	entry.Remaining = period - int(now().Unix()%int64(period))
+	return entry
 }
 
-func printCodes(urls []*otpauth.URL, filter string) {
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("Writing JSON: %v", err)
+	}
+}
+
+// codePeriod returns the period of url's codes, in seconds.
+func codePeriod(url *otpauth.URL) int {
+	if url.Period == 0 {
+		return gauth.DefaultPeriod
+	}
+	return url.Period
+}
+
+// printCodes shows the codes of the accounts of urls, with an issuer column
+// if any of them has an issuer, and a vault column if they come from several
+// vaults. Unless by is noGrouping, accounts are listed under their issuers or
+// tags instead.
+func printCodes(urls []*otpauth.URL, by grouping) {
+	showIssuer := by != byIssuer && slices.ContainsFunc(urls, func(u *otpauth.URL) bool { return u.Issuer != "" })
+	showVault := len(vaultNames) > 1
+	groups := []codeGroup{{urls: urls}}
+	switch by {
+	case byIssuer:
+		groups = groupAccounts(urls, func(u *otpauth.URL) []string {
+			if u.Issuer == "" {
+				return nil
+			}
+			return []string{u.Issuer}
+		}, "(no issuer)")
+	case byTag:
+		groups = groupAccounts(urls, accountTags, "(no tag)")
+	}
+
 	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
-	if _, err := fmt.Fprintln(tw, "\tprev\tcurr\tnext\tprog"); err != nil {
+	header := "\tprev\tcurr\tnext\tprog"
+	if showIssuer {
+		header = "\tissuer" + header
+	}
+	if showVault {
+		header = "\tvault" + header
+	}
+	if _, err := fmt.Fprintln(tw, header); err != nil {
 		log.Fatalf("Writing header: %v", err)
 	}
-	for _, url := range urls {
-		if filter != "" && !matchAccount(filter, url.Account) {
-			continue
-		}
-		prev, curr, next, err := gauth.Codes(url)
-		if err != nil {
-			log.Fatalf("Generating codes for %q: %v", url.Account, err)
-		}
-		period := url.Period
-		if period == 0 {
-			period = gauth.DefaultPeriod
+	for _, g := range groups {
+		indent := ""
+		if by != noGrouping {
+			// Empty cells keep the columns of all groups aligned.
+			if _, err := fmt.Fprintln(tw, g.name+strings.Repeat("\t", strings.Count(header, "\t"))); err != nil {
+				log.Fatalf("Writing codes: %v", err)
+			}
+			indent = "  "
 		}
-		elapsed := int(time.Now().Unix() % int64(period))
-		progress := makeProgressBar(elapsed, period)
-		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", url.Account, prev, curr, next, progress); err != nil {
-			log.Fatalf("Writing codes: %v", err)
+		for _, url := range g.urls {
+			name := indent + url.Account
+			if showVault {
+				vault, _ := vaultOf(url)
+				name += "\t" + vaultDisplayName(vault)
+			}
+			if showIssuer {
+				name += "\t" + url.Issuer
+			}
+			if url.Type == "hotp" {
+				if _, err := fmt.Fprintf(tw, "%s\t-\t-\t-\t#%d\n", name, url.Counter); err != nil {
+					log.Fatalf("Writing codes: %v", err)
+				}
+				continue
+			}
+			prev, curr, next, err := gauth.CodesAt(url, now())
+			if err != nil {
+				log.Fatalf("Generating codes for %q: %v", url.Account, err)
+			}
+			period := codePeriod(url)
+			elapsed := int(now().Unix() % int64(period))
+			progress := makeProgressBar(elapsed, period)
+			if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, prev, curr, next, progress); err != nil {
+				log.Fatalf("Writing codes: %v", err)
+			}
 		}
 	}
 	if err := tw.Flush(); err != nil {
@@ -381,6 +1631,47 @@ func printCodes(urls []*otpauth.URL, filter string) {
 	}
 }
 
+// A codeGroup is a heading of printCodes and the accounts under it.
+type codeGroup struct {
+	name string
+	urls []*otpauth.URL
+}
+
+// groupAccounts lists urls under the names that names returns for them,
+// ignoring case, in alphabetical order, with the accounts it returns none
+// for last, under other. Accounts with several names are listed under each.
+func groupAccounts(urls []*otpauth.URL, names func(*otpauth.URL) []string, other string) []codeGroup {
+	var groups []codeGroup
+	index := make(map[string]int)
+	var rest []*otpauth.URL
+	for _, url := range urls {
+		ns := names(url)
+		if len(ns) == 0 {
+			rest = append(rest, url)
+			continue
+		}
+		for _, n := range ns {
+			i, ok := index[strings.ToLower(n)]
+			if !ok {
+				i = len(groups)
+				index[strings.ToLower(n)] = i
+				groups = append(groups, codeGroup{name: n})
+			}
+			if g := groups[i].urls; len(g) > 0 && g[len(g)-1] == url {
+				continue // the same name twice
+			}
+			groups[i].urls = append(groups[i].urls, url)
+		}
+	}
+	slices.SortFunc(groups, func(a, b codeGroup) int {
+		return strings.Compare(strings.ToLower(a.name), strings.ToLower(b.name))
+	})
+	if len(rest) > 0 {
+		groups = append(groups, codeGroup{name: other, urls: rest})
+	}
+	return groups
+}
+
 func makeProgressBar(elapsed, period int) string {
 	const width = 10
 	filled := int(float64(elapsed) / float64(period) * float64(width))
@@ -0,0 +1,436 @@
+package gauth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/creachadair/otp/otpauth"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Aegis vaults are JSON documents:
+//
+//	{
+//	  "version": 1,
+//	  "header": {"slots": [...], "params": {"nonce": "...", "tag": "..."}},
+//	  "db": {"version": 3, "entries": [...], "groups": [...]}
+//	}
+//
+// In encrypted vaults, db is the base64 AES-256-GCM encryption of the JSON
+// database with a random master key, whose nonce and tag are in params. The
+// master key is itself encrypted with AES-256-GCM in each slot; password
+// slots derive their key with scrypt. Plaintext vaults have null slots and
+// params.
+
+// aegisScrypt are the scrypt parameters of new Aegis password slots, which
+// are those of the Aegis app.
+var aegisScrypt = struct{ N, R, P int }{1 << 15, 8, 1}
+
+// aegisPasswordSlot is the type of Aegis password slots.
+const aegisPasswordSlot = 1
+
+type aegisVault struct {
+	Version int             `json:"version"`
+	Header  aegisHeader     `json:"header"`
+	DB      json.RawMessage `json:"db"`
+}
+
+type aegisHeader struct {
+	Slots  []aegisSlot  `json:"slots"`
+	Params *aegisParams `json:"params"`
+}
+
+type aegisSlot struct {
+	Type      int         `json:"type"`
+	UUID      string      `json:"uuid"`
+	Key       string      `json:"key"`
+	KeyParams aegisParams `json:"key_params"`
+	N         int         `json:"n"`
+	R         int         `json:"r"`
+	P         int         `json:"p"`
+	Salt      string      `json:"salt"`
+	Repaired  bool        `json:"repaired"`
+	IsBackup  bool        `json:"is_backup"`
+}
+
+type aegisParams struct {
+	Nonce string `json:"nonce"`
+	Tag   string `json:"tag"`
+}
+
+type aegisDB struct {
+	Version        int          `json:"version"`
+	Entries        []aegisEntry `json:"entries"`
+	Groups         []aegisGroup `json:"groups"`
+	IconsOptimized bool         `json:"icons_optimized"`
+}
+
+type aegisGroup struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+type aegisEntry struct {
+	Type     string    `json:"type"`
+	UUID     string    `json:"uuid"`
+	Name     string    `json:"name"`
+	Issuer   string    `json:"issuer"`
+	Note     string    `json:"note"`
+	Favorite bool      `json:"favorite"`
+	Icon     *string   `json:"icon"`
+	Info     aegisInfo `json:"info"`
+	Group    string    `json:"group,omitempty"` // name, in version 2 databases
+	Groups   []string  `json:"groups"`          // UUIDs, in version 3 databases
+}
+
+type aegisInfo struct {
+	Secret  string  `json:"secret"`
+	Algo    string  `json:"algo"`
+	Digits  int     `json:"digits"`
+	Period  int     `json:"period,omitempty"`
+	Counter *uint64 `json:"counter,omitempty"`
+}
+
+// IsAegis reports whether data looks like an Aegis vault.
+func IsAegis(data []byte) bool {
+	var v struct {
+		Header *json.RawMessage `json:"header"`
+		DB     *json.RawMessage `json:"db"`
+	}
+	return json.Unmarshal(data, &v) == nil && v.Header != nil && v.DB != nil
+}
+
+// ParseAegis returns the accounts of the Aegis vault in data, decrypting it
+// with the password returned by getPass if it is encrypted. Notes become
+// comments, prefixed with the names of the entry's groups in brackets.
+// Entries of types gauth does not support, such as mOTP or Yandex, are
+// returned as failures.
+func ParseAegis(data []byte, getPass func() ([]byte, error)) ([]Account, []ImportFailure, error) {
+	var vault aegisVault
+	if err := json.Unmarshal(data, &vault); err != nil {
+		return nil, nil, fmt.Errorf("invalid Aegis vault: %v", err)
+	}
+	if vault.Version != 1 {
+		return nil, nil, fmt.Errorf("unsupported Aegis vault version %d", vault.Version)
+	}
+
+	plain := []byte(vault.DB)
+	if vault.Header.Params != nil {
+		passwd, err := getPass()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading passphrase: %v", err)
+		}
+		if plain, err = decryptAegis(&vault, passwd); err != nil {
+			return nil, nil, err
+		}
+	}
+	var db aegisDB
+	if err := json.Unmarshal(plain, &db); err != nil {
+		return nil, nil, fmt.Errorf("invalid Aegis database: %v", err)
+	}
+	if db.Version < 1 || db.Version > 3 {
+		return nil, nil, fmt.Errorf("unsupported Aegis database version %d", db.Version)
+	}
+
+	groups := make(map[string]string)
+	for _, g := range db.Groups {
+		groups[g.UUID] = g.Name
+	}
+	var accounts []Account
+	var failed []ImportFailure
+	for _, e := range db.Entries {
+		u := &otpauth.URL{
+			Type:      e.Type,
+			Issuer:    e.Issuer,
+			Account:   e.Name,
+			RawSecret: e.Info.Secret,
+			Algorithm: strings.ToUpper(e.Info.Algo),
+			Digits:    e.Info.Digits,
+			Period:    e.Info.Period,
+		}
+		switch e.Type {
+		case "totp":
+		case "hotp":
+			u.Period = 0
+			if e.Info.Counter != nil {
+				u.Counter = *e.Info.Counter
+			}
+		case "steam":
+			u.Algorithm, u.Digits = "", 0
+		default:
+			failed = append(failed, ImportFailure{URL: u, Err: fmt.Errorf("unsupported Aegis entry type %q", e.Type)})
+			continue
+		}
+
+		var names []string
+		if e.Group != "" {
+			names = append(names, e.Group)
+		}
+		for _, id := range e.Groups {
+			if name, ok := groups[id]; ok {
+				names = append(names, name)
+			}
+		}
+		accounts = append(accounts, Account{URL: u, Comment: JoinGroups(names, e.Note)})
+	}
+	return accounts, failed, nil
+}
+
+// decryptAegis decrypts the database of vault with passwd, trying each of
+// its password slots.
+func decryptAegis(vault *aegisVault, passwd []byte) ([]byte, error) {
+	var encoded string
+	if err := json.Unmarshal(vault.DB, &encoded); err != nil {
+		return nil, fmt.Errorf("invalid Aegis database: %v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Aegis database: %v", err)
+	}
+
+	found := false
+	for _, slot := range vault.Header.Slots {
+		if slot.Type != aegisPasswordSlot {
+			continue
+		}
+		found = true
+		if slot.N < 2 || slot.N&(slot.N-1) != 0 || slot.R < 1 || slot.R > 1<<24 || slot.P < 1 || slot.P > 16 {
+			return nil, fmt.Errorf("unsupported Aegis scrypt parameters N=%d r=%d p=%d", slot.N, slot.R, slot.P)
+		}
+		params := ScryptParams{LogN: uint8(bits.TrailingZeros(uint(slot.N))), R: uint32(slot.R), P: uint32(slot.P)}
+		if err := params.check(); err != nil {
+			return nil, err
+		}
+		salt, err := hex.DecodeString(slot.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Aegis slot salt: %v", err)
+		}
+		key, err := scrypt.Key(passwd, salt, slot.N, slot.R, slot.P, 32)
+		if err != nil {
+			return nil, err
+		}
+		encryptedKey, err := hex.DecodeString(slot.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Aegis slot key: %v", err)
+		}
+		masterKey, err := openAegis(key, slot.KeyParams, encryptedKey)
+		if err != nil {
+			continue // wrong password for this slot
+		}
+		plain, err := openAegis(masterKey, *vault.Header.Params, ciphertext)
+		if err != nil {
+			return nil, errors.New("corrupted Aegis vault")
+		}
+		return plain, nil
+	}
+	if !found {
+		return nil, errors.New("the Aegis vault has no password slot")
+	}
+	return nil, errors.New("invalid password for the Aegis vault")
+}
+
+// openAegis decrypts ciphertext with AES-256-GCM, with the nonce and tag of
+// params.
+func openAegis(key []byte, params aegisParams, ciphertext []byte) ([]byte, error) {
+	nonce, err := hex.DecodeString(params.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := hex.DecodeString(params.Tag)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, append(append([]byte{}, ciphertext...), tag...), nil)
+}
+
+// sealAegis encrypts plaintext with AES-256-GCM and a fresh nonce.
+func sealAegis(key, plaintext []byte) ([]byte, aegisParams, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, aegisParams{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, aegisParams{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, aegisParams{}, err
+	}
+	sealed := aead.Seal(nil, nonce, plaintext, nil)
+	n := len(sealed) - aead.Overhead()
+	return sealed[:n], aegisParams{Nonce: hex.EncodeToString(nonce), Tag: hex.EncodeToString(sealed[n:])}, nil
+}
+
+// ExportAegis returns accounts as an Aegis vault, encrypted with passwd
+// unless it is nil. Comments become notes, and group names in brackets at
+// their start become groups, as ParseAegis reads them.
+func ExportAegis(accounts []Account, passwd []byte) ([]byte, error) {
+	db := aegisDB{Version: 3, Entries: []aegisEntry{}, Groups: []aegisGroup{}, IconsOptimized: true}
+	groupIDs := make(map[string]string)
+	for _, a := range accounts {
+		u := a.URL
+		secret, err := u.Secret()
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid secret: %v", u.Account, err)
+		}
+		e := aegisEntry{
+			Type:   u.Type,
+			UUID:   newUUID(),
+			Name:   u.Account,
+			Issuer: u.Issuer,
+			Groups: []string{},
+			Info: aegisInfo{
+				Secret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret),
+				Algo:   strings.ToUpper(u.Algorithm),
+				Digits: u.Digits,
+				Period: u.Period,
+			},
+		}
+		if e.Info.Algo == "" {
+			e.Info.Algo = "SHA1"
+		}
+		if e.Info.Digits == 0 {
+			e.Info.Digits = 6
+		}
+		if e.Info.Period == 0 {
+			e.Info.Period = DefaultPeriod
+		}
+		switch u.Type {
+		case "totp":
+		case "hotp":
+			counter := u.Counter
+			e.Info.Period, e.Info.Counter = 0, &counter
+		case "steam":
+			e.Info.Algo, e.Info.Digits = "SHA1", steamDigits
+		default:
+			return nil, fmt.Errorf("%s: unsupported type: %q", u.Account, u.Type)
+		}
+
+		var names []string
+		names, e.Note = SplitGroups(a.Comment)
+		for _, name := range names {
+			id, ok := groupIDs[name]
+			if !ok {
+				id = newUUID()
+				groupIDs[name] = id
+				db.Groups = append(db.Groups, aegisGroup{UUID: id, Name: name})
+			}
+			e.Groups = append(e.Groups, id)
+		}
+		db.Entries = append(db.Entries, e)
+	}
+
+	plain, err := json.Marshal(db)
+	if err != nil {
+		return nil, err
+	}
+	vault := aegisVault{Version: 1, DB: plain}
+	if passwd != nil {
+		if vault, err = encryptAegis(plain, passwd); err != nil {
+			return nil, err
+		}
+	}
+	return json.MarshalIndent(vault, "", "    ")
+}
+
+// encryptAegis encrypts the database plain with a random master key, in a
+// vault with a single password slot.
+func encryptAegis(plain, passwd []byte) (aegisVault, error) {
+	masterKey := make([]byte, 32)
+	salt := make([]byte, 32)
+	if _, err := rand.Read(masterKey); err != nil {
+		return aegisVault{}, err
+	}
+	if _, err := rand.Read(salt); err != nil {
+		return aegisVault{}, err
+	}
+	key, err := scrypt.Key(passwd, salt, aegisScrypt.N, aegisScrypt.R, aegisScrypt.P, 32)
+	if err != nil {
+		return aegisVault{}, err
+	}
+	encryptedKey, keyParams, err := sealAegis(key, masterKey)
+	if err != nil {
+		return aegisVault{}, err
+	}
+	ciphertext, params, err := sealAegis(masterKey, plain)
+	if err != nil {
+		return aegisVault{}, err
+	}
+	db, err := json.Marshal(base64.StdEncoding.EncodeToString(ciphertext))
+	if err != nil {
+		return aegisVault{}, err
+	}
+	return aegisVault{
+		Version: 1,
+		Header: aegisHeader{
+			Slots: []aegisSlot{{
+				Type:      aegisPasswordSlot,
+				UUID:      newUUID(),
+				Key:       hex.EncodeToString(encryptedKey),
+				KeyParams: keyParams,
+				N:         aegisScrypt.N,
+				R:         aegisScrypt.R,
+				P:         aegisScrypt.P,
+				Salt:      hex.EncodeToString(salt),
+				Repaired:  true,
+			}},
+			Params: &params,
+		},
+		DB: db,
+	}, nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	b[6] = b[6]&0x0F | 0x40
+	b[8] = b[8]&0x3F | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// JoinGroups returns the comment describing an account in groups with the
+// given note: the group names, in brackets and separated by commas, followed
+// by the note.
+func JoinGroups(groups []string, note string) string {
+	note = strings.TrimSpace(note)
+	if len(groups) == 0 {
+		return note
+	}
+	return strings.TrimSpace("[" + strings.Join(groups, ", ") + "] " + note)
+}
+
+// SplitGroups splits a comment made by JoinGroups into its groups and note.
+func SplitGroups(comment string) (groups []string, note string) {
+	comment = strings.TrimSpace(comment)
+	end := strings.IndexByte(comment, ']')
+	if !strings.HasPrefix(comment, "[") || end < 0 {
+		return nil, comment
+	}
+	for _, g := range strings.Split(comment[1:end], ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return groups, strings.TrimSpace(comment[end+1:])
+}
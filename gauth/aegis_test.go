@@ -0,0 +1,192 @@
+package gauth_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/creachadair/otp/otpauth"
+	"github.com/pcarrier/gauth/gauth"
+)
+
+// testAegisVault is a plaintext Aegis vault with a database in version 3,
+// in the layout of the exports of the Aegis app.
+const testAegisVault = `{
+    "version": 1,
+    "header": {"slots": null, "params": null},
+    "db": {
+        "version": 3,
+        "entries": [
+            {
+                "type": "totp", "uuid": "3ae6f1ad-2e65-4ed2-a953-1ec0dff2386d",
+                "name": "Mason", "issuer": "Deno", "note": "personal", "favorite": false, "icon": null,
+                "info": {"secret": "4SJHB4GSD43FZBAI7C2HLRJGPQ", "algo": "SHA1", "digits": 6, "period": 30},
+                "groups": ["a2ba2bcb-9b33-4a22-8c0b-3f1a58dcb0e3"]
+            },
+            {
+                "type": "hotp", "uuid": "2b9cf0b5-6b1a-4b4e-8c4d-bb1c1e3e4a3c",
+                "name": "James", "issuer": "Issuu", "note": "", "favorite": false, "icon": null,
+                "info": {"secret": "YOOMIXWS5GN6RTBPUFFWKTW5M4", "algo": "SHA256", "digits": 8, "counter": 42},
+                "groups": []
+            },
+            {
+                "type": "steam", "uuid": "7d1b6f04-4e3f-4e3b-9e4a-1c1f7a5c2b11",
+                "name": "Sophia", "issuer": "Boeing", "note": "", "favorite": false, "icon": null,
+                "info": {"secret": "JRZCL47CMXVOQMNPZR2F7J4RGI", "algo": "SHA1", "digits": 5, "period": 30},
+                "groups": []
+            },
+            {
+                "type": "motp", "uuid": "0c4a8a0e-3d55-4b0f-b0a2-6e0fd5b8a6a1",
+                "name": "Benjamin", "issuer": "Air Canada", "note": "", "favorite": false, "icon": null,
+                "info": {"secret": "e3152afee62599c8", "algo": "MD5", "digits": 6, "period": 10, "pin": "1234"},
+                "groups": []
+            }
+        ],
+        "groups": [{"uuid": "a2ba2bcb-9b33-4a22-8c0b-3f1a58dcb0e3", "name": "Work"}],
+        "icons_optimized": true
+    }
+}`
+
+func noPassword() ([]byte, error) { return nil, errors.New("no password expected") }
+
+func TestParseAegis(t *testing.T) {
+	if !gauth.IsAegis([]byte(testAegisVault)) {
+		t.Fatal("IsAegis: got false")
+	}
+	accounts, failed, err := gauth.ParseAegis([]byte(testAegisVault), noPassword)
+	if err != nil {
+		t.Fatalf("ParseAegis: unexpected error: %v", err)
+	}
+	if len(accounts) != 3 || len(failed) != 1 {
+		t.Fatalf("ParseAegis: got %d accounts and %d failures, want 3 and 1", len(accounts), len(failed))
+	}
+	if failed[0].URL.Account != "Benjamin" {
+		t.Errorf("ParseAegis: %s failed, want the mOTP entry", failed[0].URL.Account)
+	}
+
+	mason := accounts[0]
+	if mason.URL.Type != "totp" || mason.URL.Issuer != "Deno" || mason.Comment != "[Work] personal" {
+		t.Errorf("ParseAegis: got %+v, %q; want Deno TOTP account commented [Work] personal", mason.URL, mason.Comment)
+	}
+	james := accounts[1].URL
+	if james.Type != "hotp" || james.Algorithm != "SHA256" || james.Digits != 8 || james.Counter != 42 {
+		t.Errorf("ParseAegis: got %+v, want HOTP account with SHA256, 8 digits and counter 42", james)
+	}
+	if sophia := accounts[2].URL; sophia.Type != "steam" {
+		t.Errorf("ParseAegis: got %+v, want Steam account", sophia)
+	}
+	for _, a := range accounts {
+		if _, _, _, err := gauth.Codes(a.URL); a.URL.Type != "hotp" && err != nil {
+			t.Errorf("Codes(%s): %v", a.URL.Account, err)
+		}
+	}
+
+	// Version 2 databases name groups directly.
+	v2 := `{"version": 1, "header": {"slots": null, "params": null}, "db": {"version": 2, "entries": [
+		{"type": "totp", "name": "web", "issuer": "", "note": "", "group": "Home",
+		 "info": {"secret": "ABCDEFGH", "algo": "SHA1", "digits": 6, "period": 30}}]}}`
+	accounts, _, err = gauth.ParseAegis([]byte(v2), noPassword)
+	if err != nil || len(accounts) != 1 || accounts[0].Comment != "[Home]" {
+		t.Errorf("ParseAegis(version 2) = %+v, %v; want web commented [Home]", accounts, err)
+	}
+}
+
+func TestExportAegis(t *testing.T) {
+	accounts, _, err := gauth.ParseAegis([]byte(testAegisVault), noPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwd := []byte("hunter2")
+	for _, p := range [][]byte{nil, passwd} {
+		data, err := gauth.ExportAegis(accounts, p)
+		if err != nil {
+			t.Fatalf("ExportAegis: unexpected error: %v", err)
+		}
+		if p != nil && bytes.Contains(data, []byte("Mason")) {
+			t.Error("ExportAegis: encrypted vault holds account names in plaintext")
+		}
+		got, failed, err := gauth.ParseAegis(data, func() ([]byte, error) { return passwd, nil })
+		if err != nil || len(failed) != 0 {
+			t.Fatalf("ParseAegis(ExportAegis()): %v, %v", failed, err)
+		}
+		if len(got) != len(accounts) {
+			t.Fatalf("round trip: got %d accounts, want %d", len(got), len(accounts))
+		}
+		for i, a := range accounts {
+			g := got[i]
+			as, _ := a.URL.Secret()
+			gs, _ := g.URL.Secret()
+			if !sameAccount(g.URL, a.URL) || !bytes.Equal(as, gs) || g.Comment != a.Comment {
+				t.Errorf("round trip: got %+v %q, want %+v %q", g.URL, g.Comment, a.URL, a.Comment)
+			}
+		}
+	}
+
+	data, err := gauth.ExportAegis(accounts, passwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := gauth.ParseAegis(data, func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+		t.Error("ParseAegis with a wrong password: got nil error")
+	}
+}
+
+// testdata/aegis-encrypted.json is an encrypted Aegis vault in the layout of
+// the exports of the Aegis app, with its default scrypt parameters, a slot
+// for the password "test" and a backup slot for the password "backup". It
+// was written independently of ExportAegis.
+func TestParseAegisEncrypted(t *testing.T) {
+	data, err := os.ReadFile("testdata/aegis-encrypted.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gauth.IsAegis(data) {
+		t.Fatal("IsAegis: got false")
+	}
+	for _, passwd := range []string{"test", "backup"} {
+		accounts, failed, err := gauth.ParseAegis(data, func() ([]byte, error) { return []byte(passwd), nil })
+		if err != nil || len(failed) != 0 {
+			t.Fatalf("ParseAegis(%q): %v, %v", passwd, failed, err)
+		}
+		if len(accounts) != 2 {
+			t.Fatalf("ParseAegis(%q): got %d accounts, want 2", passwd, len(accounts))
+		}
+		if a := accounts[0]; a.URL.Issuer != "GitHub" || a.URL.Account != "alice@example.com" || a.Comment != "[Work]" {
+			t.Errorf("ParseAegis(%q): got %+v %q, want GitHub account commented [Work]", passwd, a.URL, a.Comment)
+		}
+		if b := accounts[1].URL; b.Type != "hotp" || b.Algorithm != "SHA256" || b.Digits != 8 || b.Counter != 7 {
+			t.Errorf("ParseAegis(%q): got %+v, want HOTP account with SHA256, 8 digits and counter 7", passwd, b)
+		}
+	}
+	if _, _, err := gauth.ParseAegis(data, func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+		t.Error("ParseAegis with a wrong password: got nil error")
+	}
+
+	// Slots asking scrypt for more than 1 GiB are refused before deriving.
+	costly := bytes.Replace(data, []byte(`"n": 32768`), []byte(`"n": 1048576`), 1)
+	costly = bytes.Replace(costly, []byte(`"r": 8`), []byte(`"r": 16`), 1)
+	if _, _, err := gauth.ParseAegis(costly, func() ([]byte, error) { return []byte("test"), nil }); err == nil {
+		t.Error("ParseAegis with 2 GiB scrypt parameters: got nil error")
+	}
+}
+
+// sameAccount reports whether a and b describe the same codes, ignoring
+// how their secrets are written.
+func sameAccount(a, b *otpauth.URL) bool {
+	norm := func(u *otpauth.URL) otpauth.URL {
+		n := *u
+		n.RawSecret = ""
+		if n.Algorithm == "" {
+			n.Algorithm = "SHA1"
+		}
+		if n.Digits == 0 {
+			n.Digits = 6
+		}
+		if n.Period == 0 && n.Type != "hotp" {
+			n.Period = gauth.DefaultPeriod
+		}
+		return n
+	}
+	return norm(a) == norm(b)
+}
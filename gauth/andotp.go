@@ -0,0 +1,135 @@
+package gauth
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/otp/otpauth"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// andOTP backups are JSON lists of entries. Encrypted backups (.json.aes)
+// hold the same list, encrypted with AES-256-GCM. Those made with a
+// password start with the PBKDF2-SHA1 iteration count as a big-endian
+// 32-bit integer, a 12-byte salt and a 12-byte nonce, followed by the
+// ciphertext and its tag. Those in the old format, from before andOTP
+// 0.6.3, start with the nonce, and their key is the SHA-256 of the
+// password.
+
+const andOTPSaltSize, andOTPNonceSize, andOTPTagSize = 12, 12, 16
+
+type andOTPEntry struct {
+	Secret    *string  `json:"secret"`
+	Issuer    string   `json:"issuer"`
+	Label     string   `json:"label"`
+	Digits    int      `json:"digits"`
+	Type      *string  `json:"type"`
+	Algorithm string   `json:"algorithm"`
+	Period    int      `json:"period"`
+	Counter   uint64   `json:"counter"`
+	Tags      []string `json:"tags"`
+}
+
+// IsAndOTP reports whether data looks like a plain andOTP backup.
+func IsAndOTP(data []byte) bool {
+	var entries []andOTPEntry
+	if json.Unmarshal(data, &entries) != nil || len(entries) == 0 {
+		return false
+	}
+	for _, e := range entries {
+		if e.Secret == nil || e.Type == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAndOTP returns the accounts of the andOTP backup in data, decrypting
+// it with the password returned by getPass if it is not JSON. Encrypted
+// backups have no header to recognize them by, so ParseExport leaves them to
+// callers that know data comes from andOTP. Accounts are commented with
+// their tags in brackets.
+func ParseAndOTP(data []byte, getPass func() ([]byte, error)) ([]Account, []ImportFailure, error) {
+	if !json.Valid(data) {
+		passwd, err := getPass()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading passphrase: %v", err)
+		}
+		if data, err = decryptAndOTP(data, passwd); err != nil {
+			return nil, nil, err
+		}
+	}
+	var entries []andOTPEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, nil, fmt.Errorf("invalid andOTP backup: %v", err)
+	}
+
+	var accounts []Account
+	var failed []ImportFailure
+	for _, e := range entries {
+		u := &otpauth.URL{
+			Issuer:    e.Issuer,
+			Account:   e.Label,
+			Algorithm: strings.ToUpper(e.Algorithm),
+			Digits:    e.Digits,
+			Period:    e.Period,
+		}
+		if e.Type != nil {
+			u.Type = strings.ToLower(*e.Type)
+		}
+		if e.Secret != nil {
+			u.RawSecret = *e.Secret
+		}
+		if u.Account == "" {
+			u.Issuer, u.Account = "", e.Issuer
+		}
+		switch u.Type {
+		case "totp":
+		case "hotp":
+			u.Period = 0
+			u.Counter = e.Counter
+		case "steam":
+			u.Algorithm, u.Digits = "", 0
+		default:
+			failed = append(failed, ImportFailure{URL: u, Err: fmt.Errorf("unsupported andOTP entry type %q", u.Type)})
+			continue
+		}
+		parsed, err := reparse(u)
+		if err != nil {
+			failed = append(failed, ImportFailure{URL: u, Err: err})
+			continue
+		}
+		accounts = append(accounts, Account{URL: parsed, Comment: JoinGroups(e.Tags, "")})
+	}
+	return accounts, failed, nil
+}
+
+// decryptAndOTP decrypts an andOTP backup made with a password, or in the
+// old format.
+func decryptAndOTP(data, passwd []byte) ([]byte, error) {
+	if len(data) < andOTPNonceSize+andOTPTagSize {
+		return nil, errors.New("invalid andOTP backup: too short")
+	}
+	if len(data) >= 4+andOTPSaltSize+andOTPNonceSize+andOTPTagSize {
+		iterations := binary.BigEndian.Uint32(data)
+		if iterations >= 1 && iterations <= 1<<24 {
+			salt := data[4 : 4+andOTPSaltSize]
+			rest := data[4+andOTPSaltSize:]
+			key := pbkdf2.Key(passwd, salt, int(iterations), 32, sha1.New)
+			if plain, err := openGCM(key, rest[:andOTPNonceSize], rest[andOTPNonceSize:]); err == nil {
+				return plain, nil
+			}
+		}
+	}
+	key := sha256.Sum256(passwd)
+	plain, err := openGCM(key[:], data[:andOTPNonceSize], data[andOTPNonceSize:])
+	if err != nil {
+		return nil, errors.New("invalid password for the andOTP backup")
+	}
+	return plain, nil
+}
@@ -0,0 +1,64 @@
+package gauth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pcarrier/gauth/gauth"
+)
+
+// testdata/andotp.json.aes and testdata/andotp-old.json.aes hold the entries
+// of testdata/andotp.json, encrypted with the password "hunter2", with 1000
+// PBKDF2 iterations and in the old format.
+
+func TestParseAndOTP(t *testing.T) {
+	for _, file := range []string{"testdata/andotp.json", "testdata/andotp.json.aes", "testdata/andotp-old.json.aes"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parse := gauth.ParseAndOTP
+		if gauth.IsAndOTP(data) {
+			parse = gauth.ParseExport
+		} else if _, _, err := gauth.ParseExport(data, noPassword); err == nil {
+			t.Errorf("ParseExport(%s): got nil error, want an unrecognized format", file)
+		}
+		accounts, failed, err := parse(data, func() ([]byte, error) { return []byte("hunter2"), nil })
+		if err != nil {
+			t.Fatalf("ParseExport(%s): unexpected error: %v", file, err)
+		}
+		if len(accounts) != 4 || len(failed) != 1 {
+			t.Fatalf("ParseExport(%s): got %d accounts and %d failures, want 4 and 1", file, len(accounts), len(failed))
+		}
+		if failed[0].URL.Account != "benjamin" {
+			t.Errorf("ParseExport(%s): %s failed, want the mOTP entry", file, failed[0].URL.Account)
+		}
+
+		github := accounts[0]
+		if github.URL.Issuer != "GitHub" || github.URL.Account != "alice" || github.Comment != "[Work, Dev]" {
+			t.Errorf("ParseExport(%s): got %+v, %q; want GitHub:alice commented [Work, Dev]", file, github.URL, github.Comment)
+		}
+		bank := accounts[1].URL
+		if bank.Type != "totp" || bank.Algorithm != "SHA256" || bank.Digits != 8 || bank.Period != 60 {
+			t.Errorf("ParseExport(%s): got %+v, want TOTP account with SHA256, 8 digits and a period of 60", file, bank)
+		}
+		vpn := accounts[2].URL
+		if vpn.Type != "hotp" || vpn.Algorithm != "SHA512" || vpn.Digits != 8 || vpn.Counter != 5 {
+			t.Errorf("ParseExport(%s): got %+v, want HOTP account with SHA512, 8 digits and counter 5", file, vpn)
+		}
+		if steam := accounts[3].URL; steam.Type != "steam" || steam.Account != "Steam" {
+			t.Errorf("ParseExport(%s): got %+v, want Steam account named Steam", file, steam)
+		}
+	}
+
+	data, err := os.ReadFile("testdata/andotp.json.aes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := gauth.ParseAndOTP(data, func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+		t.Error("ParseAndOTP with a wrong password: got nil error")
+	}
+	if _, _, err := gauth.ParseAndOTP(data[:20], func() ([]byte, error) { return []byte("hunter2"), nil }); err == nil {
+		t.Error("ParseAndOTP of a truncated backup: got nil error")
+	}
+}
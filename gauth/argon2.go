@@ -0,0 +1,214 @@
+package gauth
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Argon2d, for KeePass databases. golang.org/x/crypto/argon2 only implements
+// Argon2i and Argon2id, which are used everywhere else.
+
+// argon2Block is a block of 1 KiB of Argon2 memory.
+type argon2Block [128]uint64
+
+// argon2d derives a key of keyLen bytes from password and salt with Argon2d
+// as specified in RFC 9106, with an optional secret and associated data, and
+// the given number of passes, memory in KiB and degree of parallelism.
+func argon2d(password, salt, secret, data []byte, passes, memory, lanes uint32, keyLen int) ([]byte, error) {
+	if passes < 1 || lanes < 1 || lanes > 1<<24-1 || memory < 8*lanes || keyLen < 4 {
+		return nil, errors.New("invalid Argon2d parameters")
+	}
+
+	var params [24]byte
+	for i, v := range []uint32{lanes, uint32(keyLen), memory, passes, argon2.Version, 0} {
+		binary.LittleEndian.PutUint32(params[4*i:], v)
+	}
+	h0 := blake2bSum(64, params[:], lengthPrefixed(password), lengthPrefixed(salt),
+		lengthPrefixed(secret), lengthPrefixed(data))
+
+	segment := memory / (4 * lanes)
+	columns := 4 * segment
+	mem := make([]argon2Block, columns*lanes)
+	var buf [1024]byte
+	for lane := uint32(0); lane < lanes; lane++ {
+		for j := uint32(0); j < 2; j++ {
+			var ids [8]byte
+			binary.LittleEndian.PutUint32(ids[:], j)
+			binary.LittleEndian.PutUint32(ids[4:], lane)
+			argon2Hash(buf[:], h0, ids[:])
+			b := &mem[lane*columns+j]
+			for k := range b {
+				b[k] = binary.LittleEndian.Uint64(buf[8*k:])
+			}
+		}
+	}
+
+	for pass := uint32(0); pass < passes; pass++ {
+		for slice := uint32(0); slice < 4; slice++ {
+			for lane := uint32(0); lane < lanes; lane++ {
+				argon2Segment(mem, pass, slice, lane, segment)
+			}
+		}
+	}
+
+	final := mem[columns-1]
+	for lane := uint32(1); lane < lanes; lane++ {
+		for k, v := range mem[lane*columns+columns-1] {
+			final[k] ^= v
+		}
+	}
+	for k, v := range final {
+		binary.LittleEndian.PutUint64(buf[8*k:], v)
+	}
+	out := make([]byte, keyLen)
+	argon2Hash(out, buf[:])
+	return out, nil
+}
+
+// argon2Segment fills a segment of a lane. Argon2d picks the reference block
+// from the value of the previous one.
+func argon2Segment(mem []argon2Block, pass, slice, lane, segment uint32) {
+	columns := 4 * segment
+	lanes := uint32(len(mem)) / columns
+	start := uint32(0)
+	if pass == 0 && slice == 0 {
+		start = 2 // the first blocks come from H0
+	}
+	for index := start; index < segment; index++ {
+		col := slice*segment + index
+		cur := lane*columns + col
+		prev := cur - 1
+		if col == 0 {
+			prev = cur + columns - 1
+		}
+
+		rand := mem[prev][0]
+		refLane := uint32(rand>>32) % lanes
+		if pass == 0 && slice == 0 {
+			refLane = lane
+		}
+		// The reference area holds the blocks that are already computed,
+		// except the previous one, and in other lanes those of the current
+		// slice.
+		var area uint32
+		switch {
+		case pass == 0 && refLane == lane:
+			area = col - 1
+		case pass == 0:
+			area = slice * segment
+			if index == 0 {
+				area--
+			}
+		case refLane == lane:
+			area = columns - segment + index - 1
+		default:
+			area = columns - segment
+			if index == 0 {
+				area--
+			}
+		}
+		x := rand & 0xFFFFFFFF
+		x = x * x >> 32
+		rel := uint64(area) - 1 - (uint64(area) * x >> 32)
+		startPos := uint64(0)
+		if pass != 0 && slice != 3 {
+			startPos = uint64((slice + 1) * segment)
+		}
+		ref := refLane*columns + uint32((startPos+rel)%uint64(columns))
+		argon2Compress(&mem[cur], &mem[prev], &mem[ref], pass != 0)
+	}
+}
+
+// argon2Compress sets out to G(x, y), XORed with the previous value of out
+// if xor is set.
+func argon2Compress(out, x, y *argon2Block, xor bool) {
+	var r, q argon2Block
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	q = r
+	for i := 0; i < 128; i += 16 {
+		blamka(&q[i], &q[i+1], &q[i+2], &q[i+3], &q[i+4], &q[i+5], &q[i+6], &q[i+7],
+			&q[i+8], &q[i+9], &q[i+10], &q[i+11], &q[i+12], &q[i+13], &q[i+14], &q[i+15])
+	}
+	for i := 0; i < 16; i += 2 {
+		blamka(&q[i], &q[i+1], &q[16+i], &q[16+i+1], &q[32+i], &q[32+i+1], &q[48+i], &q[48+i+1],
+			&q[64+i], &q[64+i+1], &q[80+i], &q[80+i+1], &q[96+i], &q[96+i+1], &q[112+i], &q[112+i+1])
+	}
+	for i := range out {
+		v := q[i] ^ r[i]
+		if xor {
+			v ^= out[i]
+		}
+		out[i] = v
+	}
+}
+
+// blamka is the permutation P of Argon2, applied to 16 words.
+func blamka(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+	gb(v0, v4, v8, v12)
+	gb(v1, v5, v9, v13)
+	gb(v2, v6, v10, v14)
+	gb(v3, v7, v11, v15)
+	gb(v0, v5, v10, v15)
+	gb(v1, v6, v11, v12)
+	gb(v2, v7, v8, v13)
+	gb(v3, v4, v9, v14)
+}
+
+func gb(a, b, c, d *uint64) {
+	mul := func(x, y uint64) uint64 { return 2 * (x & 0xFFFFFFFF) * (y & 0xFFFFFFFF) }
+	*a += *b + mul(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -32)
+	*c += *d + mul(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -24)
+	*a += *b + mul(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -16)
+	*c += *d + mul(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -63)
+}
+
+// argon2Hash fills out with the variable-length hash H' of the concatenation
+// of data.
+func argon2Hash(out []byte, data ...[]byte) {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(out)))
+	in := append([][]byte{size[:]}, data...)
+	if len(out) <= 64 {
+		copy(out, blake2bSum(len(out), in...))
+		return
+	}
+	v := blake2bSum(64, in...)
+	n := 0
+	for {
+		n += copy(out[n:], v[:32])
+		if len(out)-n <= 64 {
+			break
+		}
+		v = blake2bSum(64, v)
+	}
+	copy(out[n:], blake2bSum(len(out)-n, v))
+}
+
+// blake2bSum returns the BLAKE2b hash of the concatenation of data, of size
+// bytes, which must be between 1 and 64.
+func blake2bSum(size int, data ...[]byte) []byte {
+	h, err := blake2b.New(size, nil)
+	if err != nil {
+		panic(err) // invalid size
+	}
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// lengthPrefixed returns b preceded by its length, as Argon2 hashes inputs.
+func lengthPrefixed(b []byte) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(b)))
+	return append(out, b...)
+}
@@ -0,0 +1,190 @@
+package gauth
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupCount is the number of previous versions of a config kept next to
+// it, as path.bak.1 (the most recent) to path.bak.N. Backups are copies of
+// the file as it was, so encrypted configs have encrypted backups.
+// Zero disables backups.
+var BackupCount = 5
+
+// A Backup is a previous version of a config file.
+type Backup struct {
+	Index   int       // 1 for the most recent backup
+	Path    string    // location of the backup file
+	ModTime time.Time // when that version was replaced
+}
+
+// backupPath returns the location of backup number n of the config at path.
+func backupPath(path string, n int) string {
+	return path + ".bak." + strconv.Itoa(n)
+}
+
+// writeConfig replaces the config at path with data. The current version is
+// saved as the most recent backup first, and data is written to a temporary
+// file which is synced and renamed into place, so that path holds either the
+// old or the new contents even if gauth is interrupted.
+func writeConfig(path string, data []byte) error {
+	if err := rotateBackups(path); err != nil {
+		return fmt.Errorf("backing up config: %v", err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// rotateBackups shifts the backups of path by one, dropping the oldest, and
+// copies the current config into the first slot.
+func rotateBackups(path string) error {
+	if BackupCount <= 0 {
+		return nil
+	}
+	current, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for n := BackupCount - 1; n >= 1; n-- {
+		err := os.Rename(backupPath(path, n), backupPath(path, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return writeFileAtomic(backupPath(path, 1), current)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path,
+// syncs it, and renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Make the rename itself durable. Not every platform can sync a
+	// directory, and the data is already safe, so errors are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// ListBackups returns the backups of the config at path, most recent first.
+func ListBackups(path string) ([]Backup, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + ".bak."
+	var out []Backup
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 1 {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Backup{Index: n, Path: backupPath(path, n), ModTime: fi.ModTime()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Index < out[j].Index })
+	return out, nil
+}
+
+// removePlaintextBackups deletes the backups of path that are not encrypted.
+func removePlaintextBackups(path string) error {
+	backups, err := ListBackups(path)
+	if err != nil {
+		return err
+	}
+	for _, b := range backups {
+		_, isEncrypted, err := ReadConfigFile(b.Path)
+		if err != nil {
+			return err
+		}
+		if !isEncrypted {
+			if err := os.Remove(b.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reencryptBackups re-encrypts the encrypted backups of path that oldPasswd
+// decrypts with newPasswd, and deletes the other encrypted backups.
+func reencryptBackups(path string, oldPasswd, newPasswd []byte) error {
+	backups, err := ListBackups(path)
+	if err != nil {
+		return err
+	}
+	for _, b := range backups {
+		data, isEncrypted, err := ReadConfigFile(b.Path)
+		if err != nil {
+			return err
+		}
+		if !isEncrypted {
+			continue
+		}
+		plain, err := decryptConfig(data, oldPasswd)
+		if err != nil {
+			if err := os.Remove(b.Path); err != nil {
+				return err
+			}
+			continue
+		}
+		encrypted, err := reencrypt(data, oldPasswd, newPasswd, plain)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(b.Path, encrypted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreBackup replaces the config at path with its backup number n. The
+// current config is backed up first, so a restore can itself be undone.
+func RestoreBackup(path string, n int) error {
+	data, err := os.ReadFile(backupPath(path, n))
+	if err != nil {
+		return fmt.Errorf("reading backup: %v", err)
+	}
+	return writeConfig(path, data)
+}
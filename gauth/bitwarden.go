@@ -0,0 +1,232 @@
+package gauth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/creachadair/otp/otpauth"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Bitwarden exports are JSON documents listing folders and items; login
+// items may have a TOTP seed, an otpauth:// URL or a steam:// URI in their
+// login.totp field.
+//
+// Password-protected exports hold the same document, encrypted as a
+// Bitwarden "type 2" string, "2.iv|ciphertext|mac" in base64: AES-256-CBC
+// with an HMAC-SHA256 over the IV and ciphertext. The 64 bytes of keys come
+// from HKDF-Expand with "enc" and "mac" over a key derived from the password
+// with PBKDF2-SHA256 or Argon2id.
+
+// Bitwarden KDF types.
+const (
+	bitwardenPBKDF2   = 0
+	bitwardenArgon2id = 1
+)
+
+// bitwardenLogin is the type of Bitwarden login items.
+const bitwardenLogin = 1
+
+type bitwardenExport struct {
+	Encrypted         bool   `json:"encrypted"`
+	PasswordProtected bool   `json:"passwordProtected"`
+	Salt              string `json:"salt"`
+	KDFType           int    `json:"kdfType"`
+	KDFIterations     int    `json:"kdfIterations"`
+	KDFMemory         int    `json:"kdfMemory"`      // in MiB
+	KDFParallelism    int    `json:"kdfParallelism"` // lanes
+	KeyValidation     string `json:"encKeyValidation_DO_NOT_EDIT"`
+	Data              string `json:"data"`
+
+	Folders []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []struct {
+		Type     int    `json:"type"`
+		Name     string `json:"name"`
+		FolderID string `json:"folderId"`
+		Login    *struct {
+			Username string `json:"username"`
+			TOTP     string `json:"totp"`
+		} `json:"login"`
+	} `json:"items"`
+}
+
+// IsBitwarden reports whether data looks like a Bitwarden JSON export.
+func IsBitwarden(data []byte) bool {
+	var v struct {
+		Encrypted *bool            `json:"encrypted"`
+		Items     *json.RawMessage `json:"items"`
+		Data      *string          `json:"data"`
+	}
+	return json.Unmarshal(data, &v) == nil && v.Encrypted != nil && (v.Items != nil || v.Data != nil)
+}
+
+// ParseBitwarden returns the accounts of the login items with a TOTP in the
+// Bitwarden JSON export in data, decrypting it with the password returned by
+// getPass if it is password-protected. Accounts are named after the item's
+// username, with the item's name as issuer, and commented with the item's
+// folder in brackets.
+func ParseBitwarden(data []byte, getPass func() ([]byte, error)) ([]Account, []ImportFailure, error) {
+	var export bitwardenExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, nil, fmt.Errorf("invalid Bitwarden export: %v", err)
+	}
+	if export.Encrypted {
+		if !export.PasswordProtected {
+			return nil, nil, errors.New("this Bitwarden export is encrypted with the account key; export it again with a password, or unencrypted")
+		}
+		passwd, err := getPass()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading passphrase: %v", err)
+		}
+		plain, err := decryptBitwardenExport(&export, passwd)
+		if err != nil {
+			return nil, nil, err
+		}
+		export = bitwardenExport{}
+		if err := json.Unmarshal(plain, &export); err != nil {
+			return nil, nil, fmt.Errorf("invalid Bitwarden export: %v", err)
+		}
+	}
+
+	folders := make(map[string]string)
+	for _, f := range export.Folders {
+		folders[f.ID] = f.Name
+	}
+	var accounts []Account
+	var failed []ImportFailure
+	for _, item := range export.Items {
+		if item.Type != bitwardenLogin || item.Login == nil || strings.TrimSpace(item.Login.TOTP) == "" {
+			continue
+		}
+		u, err := parseBitwardenTOTP(item.Login.TOTP)
+		if u == nil {
+			u = &otpauth.URL{}
+		}
+		u.Issuer, u.Account = item.Name, item.Login.Username
+		if u.Account == "" {
+			u.Issuer, u.Account = "", item.Name
+		}
+		if err != nil {
+			failed = append(failed, ImportFailure{URL: u, Err: err})
+			continue
+		}
+		var groups []string
+		if name, ok := folders[item.FolderID]; ok {
+			groups = append(groups, name)
+		}
+		accounts = append(accounts, Account{URL: u, Comment: JoinGroups(groups, "")})
+	}
+	return accounts, failed, nil
+}
+
+// parseBitwardenTOTP parses the login.totp field of a Bitwarden item.
+func parseBitwardenTOTP(totp string) (*otpauth.URL, error) {
+	totp = strings.TrimSpace(totp)
+	switch {
+	case strings.HasPrefix(totp, "otpauth://"):
+		u, err := otpauth.ParseURL(totp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid otpauth URL: %v", err)
+		}
+		return u, nil
+	case strings.HasPrefix(totp, "steam://"):
+		return &otpauth.URL{Type: "steam", RawSecret: strings.TrimPrefix(totp, "steam://")}, nil
+	default:
+		return &otpauth.URL{Type: "totp", RawSecret: totp}, nil
+	}
+}
+
+// decryptBitwardenExport decrypts the data of a password-protected export.
+func decryptBitwardenExport(export *bitwardenExport, passwd []byte) ([]byte, error) {
+	var key []byte
+	switch export.KDFType {
+	case bitwardenPBKDF2:
+		if export.KDFIterations < 1 || export.KDFIterations > 10000000 {
+			return nil, fmt.Errorf("unsupported Bitwarden PBKDF2 iterations: %d", export.KDFIterations)
+		}
+		key = pbkdf2.Key(passwd, []byte(export.Salt), export.KDFIterations, 32, sha256.New)
+	case bitwardenArgon2id:
+		if export.KDFIterations < 1 || export.KDFIterations > 100 ||
+			export.KDFMemory < 1 || export.KDFMemory > 1024 ||
+			export.KDFParallelism < 1 || export.KDFParallelism > 64 {
+			return nil, fmt.Errorf("unsupported Bitwarden Argon2id parameters: %d iterations, %d MiB, %d lanes",
+				export.KDFIterations, export.KDFMemory, export.KDFParallelism)
+		}
+		salt := sha256.Sum256([]byte(export.Salt))
+		key = argon2.IDKey(passwd, salt[:], uint32(export.KDFIterations),
+			uint32(export.KDFMemory)*1024, uint8(export.KDFParallelism), 32)
+	default:
+		return nil, fmt.Errorf("unsupported Bitwarden KDF type %d", export.KDFType)
+	}
+
+	encKey, macKey := make([]byte, 32), make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, key, []byte("enc")), encKey); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, key, []byte("mac")), macKey); err != nil {
+		return nil, err
+	}
+	if _, err := decryptBitwardenString(export.KeyValidation, encKey, macKey); err != nil {
+		return nil, errors.New("invalid password for the Bitwarden export")
+	}
+	plain, err := decryptBitwardenString(export.Data, encKey, macKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting Bitwarden export: %v", err)
+	}
+	return plain, nil
+}
+
+// decryptBitwardenString decrypts a Bitwarden "type 2" encrypted string.
+func decryptBitwardenString(s string, encKey, macKey []byte) ([]byte, error) {
+	rest, ok := strings.CutPrefix(s, "2.")
+	if !ok {
+		return nil, errors.New("unsupported encryption type")
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid encrypted string")
+	}
+	var decoded [3][]byte
+	for i, p := range parts {
+		var err error
+		if decoded[i], err = base64.StdEncoding.DecodeString(p); err != nil {
+			return nil, fmt.Errorf("invalid encrypted string: %v", err)
+		}
+	}
+	iv, ciphertext, sum := decoded[0], decoded[1], decoded[2]
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	if !hmac.Equal(mac.Sum(nil), sum) {
+		return nil, errors.New("MAC mismatch")
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() || len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid encrypted string")
+	}
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, ciphertext)
+	n := int(plain[len(plain)-1])
+	if n == 0 || n > block.BlockSize() || !bytes.Equal(plain[len(plain)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, errors.New("invalid padding")
+	}
+	return plain[:len(plain)-n], nil
+}
@@ -0,0 +1,64 @@
+package gauth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pcarrier/gauth/gauth"
+)
+
+// testdata/bitwarden-encrypted.json is testdata/bitwarden.json exported with
+// the password "correct horse" and PBKDF2 with 1000 iterations, and
+// testdata/bitwarden-encrypted-argon2id.json with the same password and
+// Bitwarden's default Argon2id parameters: 3 iterations, 64 MiB and 4 lanes.
+
+func TestParseBitwarden(t *testing.T) {
+	for _, file := range []string{"testdata/bitwarden.json", "testdata/bitwarden-encrypted.json", "testdata/bitwarden-encrypted-argon2id.json"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !gauth.IsBitwarden(data) {
+			t.Fatalf("IsBitwarden(%s): got false", file)
+		}
+		getPass := func() ([]byte, error) { return []byte("correct horse"), nil }
+		accounts, failed, err := gauth.ParseBitwarden(data, getPass)
+		if err != nil {
+			t.Fatalf("ParseBitwarden(%s): unexpected error: %v", file, err)
+		}
+		if len(accounts) != 3 || len(failed) != 0 {
+			t.Fatalf("ParseBitwarden(%s): got %d accounts and %d failures, want 3 and 0", file, len(accounts), len(failed))
+		}
+
+		github := accounts[0]
+		if github.URL.Type != "totp" || github.URL.Issuer != "GitHub" || github.URL.Account != "alice" || github.Comment != "[Work]" {
+			t.Errorf("ParseBitwarden(%s): got %+v, %q; want GitHub:alice TOTP account commented [Work]", file, github.URL, github.Comment)
+		}
+		aws := accounts[1].URL
+		if aws.Issuer != "AWS" || aws.Account != "root" || aws.Algorithm != "SHA256" || aws.Digits != 8 {
+			t.Errorf("ParseBitwarden(%s): got %+v, want AWS:root with SHA256 and 8 digits", file, aws)
+		}
+		if steam := accounts[2].URL; steam.Type != "steam" || steam.Issuer != "" || steam.Account != "Steam" {
+			t.Errorf("ParseBitwarden(%s): got %+v, want Steam account named Steam", file, steam)
+		}
+		for _, a := range accounts {
+			if _, _, _, err := gauth.Codes(a.URL); err != nil {
+				t.Errorf("Codes(%s): %v", a.URL.Account, err)
+			}
+		}
+	}
+
+	for _, file := range []string{"testdata/bitwarden-encrypted.json", "testdata/bitwarden-encrypted-argon2id.json"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := gauth.ParseBitwarden(data, func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+			t.Errorf("ParseBitwarden(%s) with a wrong password: got nil error", file)
+		}
+	}
+	accountKey := `{"encrypted": true, "encKeyValidation_DO_NOT_EDIT": "2.AAAA|AAAA|AAAA", "folders": [], "items": []}`
+	if _, _, err := gauth.ParseBitwarden([]byte(accountKey), noPassword); err == nil {
+		t.Error("ParseBitwarden of an account-key-encrypted export: got nil error")
+	}
+}
@@ -0,0 +1,187 @@
+package gauth
+
+import (
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/otp/otpauth"
+)
+
+// FreeOTP+ exports are JSON documents holding a list of tokens and their
+// order:
+//
+//	{"tokens": [{"type": "TOTP", "issuerExt": "GitHub", "label": "alice",
+//	    "algo": "SHA1", "digits": 6, "period": 30, "counter": 0,
+//	    "secret": [72, 101, -17, ...]}], "tokenOrder": ["GitHub:alice"]}
+//
+// where secrets are lists of signed bytes, as Java has no others. The
+// original FreeOTP keeps the same tokens in an Android preferences file,
+// tokens.xml, each as a JSON string named after the token, next to the
+// tokenOrder string:
+//
+//	<map>
+//	    <string name="tokenOrder">[&quot;GitHub:alice&quot;]</string>
+//	    <string name="GitHub:alice">{&quot;type&quot;:&quot;TOTP&quot;, ...}</string>
+//	</map>
+//
+// Users may override the issuer and label of tokens, in issuerAlt and
+// labelAlt. HOTP counters are those of the next code, as in gauth.
+
+type freeOTPToken struct {
+	Type      string  `json:"type"`
+	IssuerExt string  `json:"issuerExt"`
+	IssuerAlt string  `json:"issuerAlt"`
+	Label     string  `json:"label"`
+	LabelAlt  string  `json:"labelAlt"`
+	Algo      string  `json:"algo"`
+	Digits    int     `json:"digits"`
+	Period    int     `json:"period"`
+	Counter   uint64  `json:"counter"`
+	Secret    *[]int8 `json:"secret"`
+}
+
+type freeOTPPlusExport struct {
+	Tokens *[]freeOTPToken `json:"tokens"`
+}
+
+type androidPreferences struct {
+	XMLName xml.Name `xml:"map"`
+	Strings []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"string"`
+}
+
+// IsFreeOTPPlus reports whether data looks like a FreeOTP+ JSON export.
+func IsFreeOTPPlus(data []byte) bool {
+	var export freeOTPPlusExport
+	if json.Unmarshal(data, &export) != nil || export.Tokens == nil {
+		return false
+	}
+	for _, t := range *export.Tokens {
+		if t.Secret == nil || t.Type == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseFreeOTPPlus returns the accounts of the FreeOTP+ JSON export in data.
+// Accounts are named after the token's label, with its issuer as issuer.
+func ParseFreeOTPPlus(data []byte) ([]Account, []ImportFailure, error) {
+	var export freeOTPPlusExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, nil, fmt.Errorf("invalid FreeOTP+ export: %v", err)
+	}
+	if export.Tokens == nil {
+		return nil, nil, errors.New("invalid FreeOTP+ export: no tokens")
+	}
+	accounts, failed := freeOTPAccounts(*export.Tokens)
+	return accounts, failed, nil
+}
+
+// IsFreeOTP reports whether data looks like the tokens.xml file of FreeOTP.
+func IsFreeOTP(data []byte) bool {
+	var prefs androidPreferences
+	if xml.Unmarshal(data, &prefs) != nil {
+		return false
+	}
+	for _, s := range prefs.Strings {
+		if s.Name == "tokenOrder" {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFreeOTP returns the accounts of the FreeOTP tokens.xml file in data,
+// in the order FreeOTP shows them. Accounts are named after the token's
+// label, with its issuer as issuer.
+func ParseFreeOTP(data []byte) ([]Account, []ImportFailure, error) {
+	var prefs androidPreferences
+	if err := xml.Unmarshal(data, &prefs); err != nil {
+		return nil, nil, fmt.Errorf("invalid FreeOTP tokens file: %v", err)
+	}
+	var order []string
+	values := make(map[string]string)
+	var names []string
+	for _, s := range prefs.Strings {
+		if s.Name == "tokenOrder" {
+			if err := json.Unmarshal([]byte(s.Value), &order); err != nil {
+				return nil, nil, fmt.Errorf("invalid FreeOTP token order: %v", err)
+			}
+			continue
+		}
+		values[s.Name] = s.Value
+		names = append(names, s.Name)
+	}
+
+	// Tokens missing from the order come last, as in the file.
+	seen := make(map[string]bool)
+	var tokens []freeOTPToken
+	var failed []ImportFailure
+	for _, name := range append(order, names...) {
+		value, ok := values[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		var t freeOTPToken
+		if err := json.Unmarshal([]byte(value), &t); err != nil || t.Secret == nil {
+			failed = append(failed, ImportFailure{
+				URL: &otpauth.URL{Account: name},
+				Err: errors.New("invalid FreeOTP token"),
+			})
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	accounts, tokenFailed := freeOTPAccounts(tokens)
+	return accounts, append(failed, tokenFailed...), nil
+}
+
+// freeOTPAccounts returns the accounts of FreeOTP tokens.
+func freeOTPAccounts(tokens []freeOTPToken) ([]Account, []ImportFailure) {
+	var accounts []Account
+	var failed []ImportFailure
+	for _, t := range tokens {
+		u := &otpauth.URL{
+			Type:      strings.ToLower(t.Type),
+			Issuer:    cmp.Or(t.IssuerAlt, t.IssuerExt),
+			Account:   cmp.Or(t.LabelAlt, t.Label),
+			Algorithm: strings.ToUpper(t.Algo),
+			Digits:    t.Digits,
+			Period:    t.Period,
+		}
+		if u.Account == "" {
+			u.Issuer, u.Account = "", u.Issuer
+		}
+		if t.Secret != nil {
+			secret := make([]byte, len(*t.Secret))
+			for i, b := range *t.Secret {
+				secret[i] = byte(b)
+			}
+			u.SetSecret(secret)
+		}
+		switch u.Type {
+		case "totp":
+		case "hotp":
+			u.Period = 0
+			u.Counter = t.Counter
+		default:
+			failed = append(failed, ImportFailure{URL: u, Err: fmt.Errorf("unsupported FreeOTP token type %q", t.Type)})
+			continue
+		}
+		parsed, err := reparse(u)
+		if err != nil {
+			failed = append(failed, ImportFailure{URL: u, Err: err})
+			continue
+		}
+		accounts = append(accounts, Account{URL: parsed})
+	}
+	return accounts, failed
+}
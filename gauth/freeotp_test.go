@@ -0,0 +1,60 @@
+package gauth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/creachadair/otp/otpauth"
+	"github.com/pcarrier/gauth/gauth"
+)
+
+// testdata/freeotp-tokens.xml holds the tokens of testdata/freeotp-plus.json,
+// with carol's missing from the token order.
+
+func TestParseFreeOTP(t *testing.T) {
+	alice := otpauth.URL{Type: "totp", Issuer: "GitHub", Account: "alice", RawSecret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA1", Digits: 6, Period: 30}
+	bob := otpauth.URL{Type: "hotp", Issuer: "VPN", Account: "bob", RawSecret: "ZDE4VS6MZXHM7UGR2LJ5JVOW27MNTWW3", Algorithm: "SHA256", Digits: 8, Period: 30, Counter: 5}
+	carol := otpauth.URL{Type: "totp", Issuer: "Renamed", Account: "carol@example.com", RawSecret: "GAYTEMZUGU3DOOBZ", Algorithm: "SHA512", Digits: 6, Period: 60}
+
+	for _, tc := range []struct {
+		file  string
+		is    func([]byte) bool
+		parse func([]byte) ([]gauth.Account, []gauth.ImportFailure, error)
+		want  []otpauth.URL
+	}{
+		{"testdata/freeotp-plus.json", gauth.IsFreeOTPPlus, gauth.ParseFreeOTPPlus, []otpauth.URL{alice, bob, carol}},
+		{"testdata/freeotp-tokens.xml", gauth.IsFreeOTP, gauth.ParseFreeOTP, []otpauth.URL{alice, bob, carol}},
+	} {
+		data, err := os.ReadFile(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tc.is(data) {
+			t.Fatalf("%s: not detected", tc.file)
+		}
+		accounts, failed, err := tc.parse(data)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.file, err)
+		}
+		if len(accounts) != len(tc.want) || len(failed) != 0 {
+			t.Fatalf("%s: got %d accounts and %d failures, want %d and 0", tc.file, len(accounts), len(failed), len(tc.want))
+		}
+		for i, a := range accounts {
+			if *a.URL != tc.want[i] {
+				t.Errorf("%s: account %d: got %+v, want %+v", tc.file, i, *a.URL, tc.want[i])
+			}
+		}
+
+		imported, _, err := gauth.ParseExport(data, nil)
+		if err != nil || len(imported) != len(tc.want) {
+			t.Errorf("ParseExport(%s): got %d accounts, %v; want %d", tc.file, len(imported), err, len(tc.want))
+		}
+	}
+
+	if gauth.IsFreeOTPPlus([]byte(`{"tokens": [{"type": "TOTP"}]}`)) {
+		t.Error("IsFreeOTPPlus of a token without secret: got true")
+	}
+	if gauth.IsFreeOTP([]byte(`<map><string name="theme">dark</string></map>`)) {
+		t.Error("IsFreeOTP of other preferences: got true")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -13,15 +14,20 @@ import (
 	"fmt"
 	"hash"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/creachadair/otp"
 	"github.com/creachadair/otp/otpauth"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
+	steamAlphabet = "23456789BCDFGHJKMNPQRTVWXY"
+	steamDigits   = 5
 	saltedPrefix  = "Salted__"
-	aesKeySize    = 16
 	DefaultPeriod = 30
 	blockSize     = 16
 	saltOffset    = 8
@@ -29,6 +35,80 @@ const (
 	minPadding    = 1
 )
 
+// Encryption describes how an OpenSSL "Salted__" config file is encrypted.
+type Encryption struct {
+	KeySize int // AES key size in bytes: 16, 24 or 32
+	Iter    int // PBKDF2-HMAC-SHA256 iterations; 0 selects EVP_BytesToKey
+}
+
+// LegacyEncryption matches "openssl enc -aes-128-cbc -md sha256".
+var LegacyEncryption = Encryption{KeySize: 16}
+
+// PBKDF2Encryption matches "openssl enc -aes-256-cbc -pbkdf2", whose default
+// iteration count is 10000.
+var PBKDF2Encryption = Encryption{KeySize: 32, Iter: 10000}
+
+// Encryptions lists the schemes tried, in order, when decrypting a config.
+// Callers using a non-default key size or iteration count should add it here.
+var Encryptions = []Encryption{LegacyEncryption, PBKDF2Encryption}
+
+// String returns the openssl enc flags matching e.
+func (e Encryption) String() string {
+	if e.Iter == 0 {
+		return fmt.Sprintf("-aes-%d-cbc -md sha256", e.KeySize*8)
+	}
+	return fmt.Sprintf("-aes-%d-cbc -pbkdf2 -iter %d", e.KeySize*8, e.Iter)
+}
+
+// ParseEncryption parses openssl enc flags such as "-aes-256-cbc -pbkdf2
+// -iter 100000" into an Encryption. The cipher defaults to AES-256 when
+// -pbkdf2 is given and AES-128 otherwise; -md sha256 is accepted and ignored.
+func ParseEncryption(s string) (Encryption, error) {
+	var e Encryption
+	pbkdf := false
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		switch f := strings.TrimPrefix(fields[i], "-"); f {
+		case "aes-128-cbc":
+			e.KeySize = 16
+		case "aes-192-cbc":
+			e.KeySize = 24
+		case "aes-256-cbc":
+			e.KeySize = 32
+		case "pbkdf2":
+			pbkdf = true
+		case "md":
+			if i+1 >= len(fields) || !strings.EqualFold(fields[i+1], "sha256") {
+				return Encryption{}, errors.New("only -md sha256 is supported")
+			}
+			i++
+		case "iter":
+			if i+1 >= len(fields) {
+				return Encryption{}, errors.New("missing value for -iter")
+			}
+			n, err := strconv.Atoi(fields[i+1])
+			if err != nil || n <= 0 {
+				return Encryption{}, fmt.Errorf("invalid iteration count %q", fields[i+1])
+			}
+			e.Iter = n
+			pbkdf = true
+			i++
+		default:
+			return Encryption{}, fmt.Errorf("unsupported flag %q", fields[i])
+		}
+	}
+	if pbkdf && e.Iter == 0 {
+		e.Iter = PBKDF2Encryption.Iter
+	}
+	if e.KeySize == 0 {
+		e.KeySize = LegacyEncryption.KeySize
+		if pbkdf {
+			e.KeySize = PBKDF2Encryption.KeySize
+		}
+	}
+	return e, nil
+}
+
 // pickAlgorithm returns a constructor for the named hash function, or
 // an error if the name is not a supported algorithm.
 func pickAlgorithm(name string) (func() hash.Hash, error) {
@@ -46,36 +126,90 @@ func pickAlgorithm(name string) (func() hash.Hash, error) {
 
 // Codes returns the previous, current, and next codes from u.
 func Codes(u *otpauth.URL) (prev, curr, next string, _ error) {
-	var ts uint64
 	if u.Period == 0 {
 		u.Period = DefaultPeriod
 	}
-	ts = otp.TimeWindow(u.Period)()
-	return CodesAtTimeStep(u, ts)
+	return CodesAt(u, time.Now())
+}
+
+// CodesAt returns the previous, current, and next codes from u at time t,
+// which must not be before 1970.
+func CodesAt(u *otpauth.URL, t time.Time) (prev, curr, next string, _ error) {
+	period := u.Period
+	if period == 0 {
+		period = DefaultPeriod
+	}
+	if t.Unix() < 0 {
+		return "", "", "", fmt.Errorf("time %v is before 1970", t)
+	}
+	return CodesAtTimeStep(u, uint64(t.Unix())/uint64(period))
 }
 
 // CodesAtTimeStep returns the previous, current, and next codes from u at the
-// given time step value.
+// given time step value. Steam Guard URLs (type "steam") are time-based too,
+// and yield 5-character codes from the Steam alphabet.
 func CodesAtTimeStep(u *otpauth.URL, timeStep uint64) (prev, curr, next string, _ error) {
-	if u.Type != "totp" {
+	if u.Type != "totp" && u.Type != "steam" {
 		return "", "", "", fmt.Errorf("unsupported type: %q", u.Type)
 	}
 
-	alg, err := pickAlgorithm(u.Algorithm)
+	cfg, err := newConfig(u)
 	if err != nil {
 		return "", "", "", err
 	}
-
-	cfg := otp.Config{Hash: alg, Digits: u.Digits}
-	if err := cfg.ParseKey(u.RawSecret); err != nil {
-		return "", "", "", fmt.Errorf("invalid secret: %v", err)
-	}
 	prev = cfg.HOTP(timeStep - 1)
 	curr = cfg.HOTP(timeStep)
 	next = cfg.HOTP(timeStep + 1)
 	return
 }
 
+// NextHOTP returns the code for the counter value stored in u, which must be
+// an HOTP URL, and advances u.Counter past it. Callers are responsible for
+// persisting the new counter before showing the code to the user.
+func NextHOTP(u *otpauth.URL) (string, error) {
+	if u.Type != "hotp" {
+		return "", fmt.Errorf("unsupported type: %q", u.Type)
+	}
+
+	cfg, err := newConfig(u)
+	if err != nil {
+		return "", err
+	}
+	code := cfg.HOTP(u.Counter)
+	u.Counter++
+	return code, nil
+}
+
+// formatSteam formats hash as a Steam Guard code. Unlike otp.FormatAlphabet,
+// Steam emits the least significant symbol first.
+func formatSteam(hash []byte, width int) string {
+	code := otp.Truncate(hash)
+	out := make([]byte, width)
+	for i := range out {
+		out[i] = steamAlphabet[code%uint64(len(steamAlphabet))]
+		code /= uint64(len(steamAlphabet))
+	}
+	return string(out)
+}
+
+// newConfig returns an OTP generator configured from the parameters of u.
+func newConfig(u *otpauth.URL) (otp.Config, error) {
+	alg, err := pickAlgorithm(u.Algorithm)
+	if err != nil {
+		return otp.Config{}, err
+	}
+
+	cfg := otp.Config{Hash: alg, Digits: u.Digits}
+	if u.Type == "steam" {
+		cfg.Digits = steamDigits
+		cfg.Format = formatSteam
+	}
+	if err := cfg.ParseKey(u.RawSecret); err != nil {
+		return otp.Config{}, fmt.Errorf("invalid secret: %v", err)
+	}
+	return cfg, nil
+}
+
 // ReadConfigFile reads the config file at path and returns its contents and
 // whether it is encrypted or not
 func ReadConfigFile(path string) ([]byte, bool, error) {
@@ -84,7 +218,7 @@ func ReadConfigFile(path string) ([]byte, bool, error) {
 		return nil, false, err
 	}
 
-	if bytes.HasPrefix(data, []byte(saltedPrefix)) {
+	if bytes.HasPrefix(data, []byte(saltedPrefix)) || IsNative(data) {
 		return data, true, nil // encrypted
 	}
 
@@ -113,33 +247,98 @@ func LoadConfigFile(path string, getPass func() ([]byte, error)) ([]byte, error)
 
 // decryptConfig handles the decryption of encrypted configuration data
 func decryptConfig(data, passwd []byte) ([]byte, error) {
-	if len(data) < saltOffset+saltSize {
-		return nil, errors.New("encrypted data too short")
+	if IsNative(data) {
+		return DecryptNative(data, passwd)
 	}
+	_, plain, err := detectEncryption(data, passwd)
+	return plain, err
+}
 
+// detectEncryption decrypts data with each of Encryptions in turn, and returns
+// the first scheme that yields well-formed plaintext along with that plaintext.
+// OpenSSL does not record the KDF in its output, so trying is the only way.
+func detectEncryption(data, passwd []byte) (Encryption, []byte, error) {
+	if len(data) < saltOffset+saltSize {
+		return Encryption{}, nil, errors.New("encrypted data too short")
+	}
 	salt := data[saltOffset : saltOffset+saltSize]
 	rest := data[saltOffset+saltSize:]
+	if len(rest) == 0 || len(rest)%blockSize != 0 {
+		return Encryption{}, nil, errors.New("encrypted data is not a whole number of blocks")
+	}
 
-	key, iv := deriveKeyAndIV(passwd, salt)
+	var firstErr error
+	for _, enc := range Encryptions {
+		plain, err := decryptWith(enc, passwd, salt, rest)
+		if err == nil && !utf8.Valid(plain) {
+			err = errors.New("invalid decryption key")
+		}
+		if err == nil {
+			return enc, plain, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = errors.New("no encryption schemes configured")
+	}
+	return Encryption{}, nil, firstErr
+}
+
+// decryptWith decrypts ciphertext, which must be a whole number of blocks,
+// using the key and IV derived from passwd and salt according to enc.
+func decryptWith(enc Encryption, passwd, salt, ciphertext []byte) ([]byte, error) {
+	key, iv, err := enc.deriveKeyAndIV(passwd, salt)
+	if err != nil {
+		return nil, err
+	}
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("creating cipher: %v", err)
 	}
 
+	plain := make([]byte, len(ciphertext))
 	mode := cipher.NewCBCDecrypter(block, iv)
-	mode.CryptBlocks(rest, rest)
+	mode.CryptBlocks(plain, ciphertext)
 
-	return removePadding(rest)
+	return removePadding(plain)
 }
 
 // deriveKeyAndIV generates the key and IV from password and salt
-func deriveKeyAndIV(passwd, salt []byte) (key, iv []byte) {
-	salting := sha256.New()
-	salting.Write(passwd)
-	salting.Write(salt)
-	sum := salting.Sum(nil)
-	return sum[:blockSize], sum[blockSize:]
+func (e Encryption) deriveKeyAndIV(passwd, salt []byte) (key, iv []byte, err error) {
+	switch e.KeySize {
+	case 16, 24, 32:
+	default:
+		return nil, nil, fmt.Errorf("invalid AES key size %d", e.KeySize)
+	}
+	if e.Iter < 0 {
+		return nil, nil, fmt.Errorf("invalid iteration count %d", e.Iter)
+	}
+
+	var sum []byte
+	if e.Iter > 0 {
+		sum = pbkdf2.Key(passwd, salt, e.Iter, e.KeySize+blockSize, sha256.New)
+	} else {
+		sum = evpBytesToKey(passwd, salt, e.KeySize+blockSize)
+	}
+	return sum[:e.KeySize], sum[e.KeySize:], nil
+}
+
+// evpBytesToKey implements OpenSSL's legacy EVP_BytesToKey derivation with a
+// single round of SHA-256, as used by "openssl enc -md sha256" without -pbkdf2.
+func evpBytesToKey(passwd, salt []byte, size int) []byte {
+	var out, prev []byte
+	for len(out) < size {
+		h := sha256.New()
+		h.Write(prev)
+		h.Write(passwd)
+		h.Write(salt)
+		prev = h.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:size]
 }
 
 // removePadding removes and validates PKCS#7 padding
@@ -163,38 +362,177 @@ func removePadding(data []byte) ([]byte, error) {
 }
 
 // WriteConfigFile encrypts the provided newConfig using passwd, if necessary,
-// and writes it to path
+// and writes it to path. Encrypted files keep the scheme they were written
+// with: native vaults keep their KDF parameters, and the OpenSSL scheme is
+// detected by decrypting the file with passwd. If path does not exist, it is
+// created as a native vault if passwd is not nil. A fresh salt is used on
+// every write.
 func WriteConfigFile(path string, passwd []byte, newConfig []byte) error {
+	return writeConfigFile(path, passwd, newConfig, true)
+}
+
+// writeConfigFile is WriteConfigFile, which only backs up the current config
+// if backup is set.
+func writeConfigFile(path string, passwd []byte, newConfig []byte, backup bool) error {
+	write := writeConfig
+	if !backup {
+		write = writeFileAtomic
+	}
 	data, isEncrypted, err := ReadConfigFile(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// If the file doesn't exist, treat it as non-encrypted
-			isEncrypted = false
-		} else {
+		if !os.IsNotExist(err) {
 			return fmt.Errorf("reading config file: %v", err)
 		}
+		if passwd == nil {
+			return write(path, newConfig)
+		}
+		// New configs with a password are created as native vaults.
+		vault, err := EncryptNative(DefaultScryptParams, passwd, newConfig)
+		if err != nil {
+			return fmt.Errorf("encrypting config: %v", err)
+		}
+		return write(path, vault)
 	}
 
 	if !isEncrypted {
-		return os.WriteFile(path, newConfig, 0600)
+		return write(path, newConfig)
+	}
+
+	encryptedConfig, err := reencrypt(data, passwd, passwd, newConfig)
+	if err != nil {
+		return err
+	}
+	return write(path, encryptedConfig)
+}
+
+// EncryptConfigFile encrypts the plaintext config at path as a native vault
+// protected by passwd. The plaintext is not kept as a backup, and existing
+// plaintext backups are deleted.
+func EncryptConfigFile(path string, passwd []byte) error {
+	data, isEncrypted, err := ReadConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	if isEncrypted {
+		return errors.New("config is already encrypted")
 	}
 
-	encryptedConfig, err := encryptConfig(data[8:16], passwd, newConfig)
+	vault, err := EncryptNative(DefaultScryptParams, passwd, data)
 	if err != nil {
 		return fmt.Errorf("encrypting config: %v", err)
 	}
+	if err := checkDecrypts(vault, passwd, data); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, vault); err != nil {
+		return err
+	}
+	if err := removePlaintextBackups(path); err != nil {
+		return fmt.Errorf("removing plaintext backups: %v", err)
+	}
+	return nil
+}
 
-	return os.WriteFile(path, encryptedConfig, 0600)
+// DecryptConfigFile replaces the encrypted config at path with its plaintext.
+func DecryptConfigFile(path string, passwd []byte) error {
+	data, isEncrypted, err := ReadConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	if !isEncrypted {
+		return errors.New("config is not encrypted")
+	}
+
+	plain, err := decryptConfig(data, passwd)
+	if err != nil {
+		return fmt.Errorf("decrypting config: %v", err)
+	}
+	return writeConfig(path, plain)
+}
+
+// ChangePassword re-encrypts the config at path with newPasswd instead of
+// oldPasswd, keeping its encryption scheme. Its backups are re-encrypted too,
+// so that the old password decrypts none of them and restoring one does not
+// bring it back; encrypted backups that oldPasswd does not decrypt, from
+// earlier passwords, are deleted.
+func ChangePassword(path string, oldPasswd, newPasswd []byte) error {
+	data, isEncrypted, err := ReadConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	if !isEncrypted {
+		return errors.New("config is not encrypted")
+	}
+
+	plain, err := decryptConfig(data, oldPasswd)
+	if err != nil {
+		return fmt.Errorf("decrypting config: %v", err)
+	}
+	encryptedConfig, err := reencrypt(data, oldPasswd, newPasswd, plain)
+	if err != nil {
+		return err
+	}
+	if err := writeConfig(path, encryptedConfig); err != nil {
+		return err
+	}
+	if err := reencryptBackups(path, oldPasswd, newPasswd); err != nil {
+		return fmt.Errorf("re-encrypting backups: %v", err)
+	}
+	return nil
+}
+
+// reencrypt encrypts config with newPasswd, using the same scheme as the
+// encrypted config in data, which must decrypt with oldPasswd. The result
+// uses a fresh salt, and is checked to decrypt back to config.
+func reencrypt(data, oldPasswd, newPasswd, config []byte) ([]byte, error) {
+	var out []byte
+	if IsNative(data) {
+		params, err := nativeParams(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading vault header: %v", err)
+		}
+		if _, err := DecryptNative(data, oldPasswd); err != nil {
+			return nil, fmt.Errorf("decrypting config: %v", err)
+		}
+		if out, err = EncryptNative(params, newPasswd, config); err != nil {
+			return nil, fmt.Errorf("encrypting config: %v", err)
+		}
+	} else {
+		enc, _, err := detectEncryption(data, oldPasswd)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting config: %v", err)
+		}
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("generating salt: %v", err)
+		}
+		if out, err = encryptConfig(enc, salt, newPasswd, config); err != nil {
+			return nil, fmt.Errorf("encrypting config: %v", err)
+		}
+	}
+	if err := checkDecrypts(out, newPasswd, config); err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func encryptConfig(salt, passwd, config []byte) ([]byte, error) {
-	salting := sha256.New()
-	salting.Write(passwd)
-	salting.Write(salt)
-	sum := salting.Sum(nil)
+// checkDecrypts verifies that the encrypted config in data decrypts to want.
+func checkDecrypts(data, passwd, want []byte) error {
+	got, err := decryptConfig(data, passwd)
+	if err != nil {
+		return fmt.Errorf("verifying encrypted config: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		return errors.New("verifying encrypted config: contents differ")
+	}
+	return nil
+}
 
-	key := sum[:aesKeySize]
-	iv := sum[aesKeySize:]
+func encryptConfig(enc Encryption, salt, passwd, config []byte) ([]byte, error) {
+	key, iv, err := enc.deriveKeyAndIV(passwd, salt)
+	if err != nil {
+		return nil, err
+	}
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -203,7 +541,7 @@ func encryptConfig(salt, passwd, config []byte) ([]byte, error) {
 
 	// Add padding
 	padLength := blockSize - (len(config) % blockSize)
-	paddedConfig := append(config, bytes.Repeat([]byte{byte(padLength)}, padLength)...)
+	paddedConfig := append(config[:len(config):len(config)], bytes.Repeat([]byte{byte(padLength)}, padLength)...)
 
 	// Encrypt
 	mode := cipher.NewCBCEncrypter(block, iv)
@@ -215,46 +553,73 @@ func encryptConfig(salt, passwd, config []byte) ([]byte, error) {
 
 // ParseConfig parses the contents of data as a gauth configuration file.
 // Returns a slice of otpauth URLs representing the parsed configurations.
+// Use ParseVault to edit the configuration.
 func ParseConfig(data []byte) ([]*otpauth.URL, error) {
-	var out []*otpauth.URL
-	lines := strings.Split(string(data), "\n")
-
-	for i, line := range lines {
-		trim := strings.TrimSpace(line)
-		if trim == "" {
-			continue
-		}
-
-		url, err := parseConfigLine(trim, i+1)
-		if err != nil {
-			return nil, err
-		}
-
-		if url != nil {
-			out = append(out, url)
-		}
+	v, err := ParseVault(data)
+	if err != nil {
+		return nil, err
 	}
-	return out, nil
+	return v.URLs(), nil
 }
 
 // parseConfigLine parses a single line of configuration
 func parseConfigLine(line string, lineNum int) (*otpauth.URL, error) {
+	u, err := parseAccount(line)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", lineNum, err)
+	}
+	return u, nil
+}
+
+// parseAccount parses an account as the config holds it, either as an
+// otpauth URL or as name:secret[:counter], where name may be issuer/account.
+func parseAccount(line string) (*otpauth.URL, error) {
 	if strings.HasPrefix(line, "otpauth://") {
 		u, err := otpauth.ParseURL(line)
 		if err != nil {
-			return nil, fmt.Errorf("line %d: invalid otpauth URL: %v", lineNum, err)
+			return nil, fmt.Errorf("invalid otpauth URL: %v", err)
 		}
 		return u, nil
 	}
 
-	parts := strings.SplitN(line, ":", 2)
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("line %d: invalid format (want name:secret)", lineNum)
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) < 2 {
+		return nil, errors.New("invalid format (want name:secret)")
 	}
 
-	return &otpauth.URL{
+	u := &otpauth.URL{
 		Type:      "totp",
 		Account:   strings.TrimSpace(parts[0]),
 		RawSecret: strings.TrimSpace(parts[1]),
-	}, nil
+	}
+	if issuer, account, ok := strings.Cut(u.Account, "/"); ok {
+		issuer, account = strings.TrimSpace(issuer), strings.TrimSpace(account)
+		if issuer != "" && account != "" {
+			u.Issuer, u.Account = issuer, account
+		}
+	}
+	if len(parts) == 3 {
+		// name:secret:counter describes an HOTP account.
+		counter, err := strconv.ParseUint(strings.TrimSpace(parts[2]), 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid counter (want name:secret:counter)")
+		}
+		u.Type = "hotp"
+		u.Counter = counter
+	}
+	return u, nil
+}
+
+// FormatLegacyLine returns u in the name:secret form, with a trailing
+// counter for HOTP accounts. The name of accounts with an issuer is
+// issuer/account.
+func FormatLegacyLine(u *otpauth.URL) string {
+	line := u.Account + ":" + u.RawSecret
+	if u.Issuer != "" {
+		line = u.Issuer + "/" + line
+	}
+	if u.Type == "hotp" {
+		line += ":" + strconv.FormatUint(u.Counter, 10)
+	}
+	return line
 }
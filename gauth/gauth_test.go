@@ -2,7 +2,12 @@ package gauth_test
 
 import (
 	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/creachadair/otp/otpauth"
 	"github.com/pcarrier/gauth/gauth"
@@ -32,7 +37,28 @@ func TestCodes(t *testing.T) {
 	}
 }
 
+func TestCodesAt(t *testing.T) {
+	// The time step of TestCodes, 30 seconds long, seen near its end.
+	u := &otpauth.URL{Type: "totp", RawSecret: "ABCDEFGH"}
+	at := time.Unix(51790421*30+29, 0)
+	if _, got, _, err := gauth.CodesAt(u, at); err != nil || got != "305441" {
+		t.Errorf("CodesAt(%v): got %q, %v; want 305441", at, got, err)
+	}
+
+	u.Period = 60
+	at = time.Unix(51790421*60, 0)
+	if _, got, _, err := gauth.CodesAt(u, at); err != nil || got != "305441" {
+		t.Errorf("CodesAt(%v) with a period of 60: got %q, %v; want 305441", at, got, err)
+	}
+
+	if _, _, _, err := gauth.CodesAt(u, time.Unix(-1, 0)); err == nil {
+		t.Error("CodesAt before 1970: got nil error")
+	}
+}
+
 //go:generate openssl enc -aes-128-cbc -md sha256 -pass pass:x -in testdata/plaintext.csv -out testdata/encrypted.csv
+//go:generate openssl enc -aes-256-cbc -pbkdf2 -pass pass:x -in testdata/plaintext.csv -out testdata/encrypted-pbkdf2.csv
+//go:generate openssl enc -aes-192-cbc -pbkdf2 -iter 1234 -pass pass:x -in testdata/plaintext.csv -out testdata/encrypted-pbkdf2-iter.csv
 
 func TestLoadConfig(t *testing.T) {
 
@@ -72,3 +98,474 @@ func TestLoadConfig(t *testing.T) {
 		t.Errorf("Decrypted not equal to plaintext:\ngot  %+v\nwant %+v", enc, plain)
 	}
 }
+
+func TestNextHOTP(t *testing.T) {
+	// Test vectors from RFC 4226 Appendix D.
+	u := &otpauth.URL{Type: "hotp", RawSecret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"}
+	for i, want := range []string{"755224", "287082", "359152", "969429"} {
+		got, err := gauth.NextHOTP(u)
+		if err != nil {
+			t.Fatalf("NextHOTP at %d: unexpected error: %v", i, err)
+		} else if got != want {
+			t.Errorf("NextHOTP at %d: got %q, want %q", i, got, want)
+		}
+		if u.Counter != uint64(i+1) {
+			t.Errorf("NextHOTP at %d: counter is %d, want %d", i, u.Counter, i+1)
+		}
+	}
+
+	if _, err := gauth.NextHOTP(&otpauth.URL{Type: "totp", RawSecret: "ABCDEFGH"}); err == nil {
+		t.Error("NextHOTP on a TOTP URL: got nil error")
+	}
+}
+
+func TestParseConfigHOTP(t *testing.T) {
+	urls, err := gauth.ParseConfig([]byte("vpn: GEZDGNBVGY3TQOJQ :42\nweb:ABCDEFGH\n"))
+	if err != nil {
+		t.Fatalf("ParseConfig: unexpected error: %v", err)
+	}
+	if len(urls) != 2 {
+		t.Fatalf("ParseConfig: got %d URLs, want 2", len(urls))
+	}
+	if u := urls[0]; u.Type != "hotp" || u.Counter != 42 || u.RawSecret != "GEZDGNBVGY3TQOJQ" {
+		t.Errorf("ParseConfig: got %+v, want hotp with counter 42", u)
+	}
+	if got, want := gauth.FormatLegacyLine(urls[0]), "vpn:GEZDGNBVGY3TQOJQ:42"; got != want {
+		t.Errorf("FormatLegacyLine: got %q, want %q", got, want)
+	}
+	if u := urls[1]; u.Type != "totp" {
+		t.Errorf("ParseConfig: got type %q, want totp", u.Type)
+	}
+
+	if _, err := gauth.ParseConfig([]byte("vpn:ABCDEFGH:many\n")); err == nil {
+		t.Error("ParseConfig with an invalid counter: got nil error")
+	}
+}
+
+func TestParseConfigIssuer(t *testing.T) {
+	urls, err := gauth.ParseConfig([]byte("GitHub / admin:ABCDEFGH\nAWS/admin:ABCDEFGH:3\nhalf/:ABCDEFGH\n"))
+	if err != nil {
+		t.Fatalf("ParseConfig: unexpected error: %v", err)
+	}
+	if u := urls[0]; u.Issuer != "GitHub" || u.Account != "admin" {
+		t.Errorf("ParseConfig: got %+v, want admin of GitHub", u)
+	}
+	if u := urls[1]; u.Issuer != "AWS" || u.Account != "admin" || u.Type != "hotp" || u.Counter != 3 {
+		t.Errorf("ParseConfig: got %+v, want HOTP admin of AWS with counter 3", u)
+	}
+	if u := urls[2]; u.Issuer != "" || u.Account != "half/" {
+		t.Errorf("ParseConfig: got %+v, want half/ without issuer", u)
+	}
+	for i, want := range []string{"GitHub/admin:ABCDEFGH", "AWS/admin:ABCDEFGH:3"} {
+		if got := gauth.FormatLegacyLine(urls[i]); got != want {
+			t.Errorf("FormatLegacyLine: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSteamCodes(t *testing.T) {
+	u, err := otpauth.ParseURL("otpauth://steam/Steam:gamer?secret=ABCDEFGH")
+	if err != nil {
+		t.Fatalf("ParseURL: unexpected error: %v", err)
+	}
+	prev, curr, next, err := gauth.CodesAtTimeStep(u, 51790421)
+	if err != nil {
+		t.Fatalf("CodesAtTimeStep: unexpected error: %v", err)
+	}
+	// Same counter as TestCodes, where the truncated hash ends in 305441.
+	if want := "TBW66"; curr != want {
+		t.Errorf("CodesAtTimeStep: got %q, want %q", curr, want)
+	}
+	for _, code := range []string{prev, next} {
+		if len(code) != 5 {
+			t.Errorf("CodesAtTimeStep: got %q, want 5 characters", code)
+		}
+	}
+}
+
+func TestLoadConfigPBKDF2(t *testing.T) {
+	getPass := func() ([]byte, error) { return []byte("x"), nil }
+	plain, err := gauth.LoadConfigFile("testdata/plaintext.csv", getPass)
+	if err != nil {
+		t.Fatalf("Loading plaintext config: %v", err)
+	}
+
+	// The default PBKDF2 settings are detected without configuration.
+	enc, err := gauth.LoadConfigFile("testdata/encrypted-pbkdf2.csv", getPass)
+	if err != nil {
+		t.Fatalf("Loading PBKDF2 config: %v", err)
+	} else if !bytes.Equal(plain, enc) {
+		t.Errorf("Decrypted not equal to plaintext:\ngot  %+v\nwant %+v", enc, plain)
+	}
+
+	// Custom parameters have to be configured.
+	if _, err := gauth.LoadConfigFile("testdata/encrypted-pbkdf2-iter.csv", getPass); err == nil {
+		t.Error("Loading PBKDF2 config with unknown parameters: got nil error")
+	}
+	custom, err := gauth.ParseEncryption("-aes-192-cbc -pbkdf2 -iter 1234")
+	if err != nil {
+		t.Fatalf("ParseEncryption: unexpected error: %v", err)
+	}
+	defer func(saved []gauth.Encryption) { gauth.Encryptions = saved }(gauth.Encryptions)
+	gauth.Encryptions = append(gauth.Encryptions, custom)
+	enc, err = gauth.LoadConfigFile("testdata/encrypted-pbkdf2-iter.csv", getPass)
+	if err != nil {
+		t.Fatalf("Loading PBKDF2 config with custom parameters: %v", err)
+	} else if !bytes.Equal(plain, enc) {
+		t.Errorf("Decrypted not equal to plaintext:\ngot  %+v\nwant %+v", enc, plain)
+	}
+}
+
+func TestWriteConfigKeepsEncryption(t *testing.T) {
+	orig, err := os.ReadFile("testdata/encrypted-pbkdf2.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "gauth.csv")
+	if err := os.WriteFile(path, orig, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte("updated:ABCDEFGH\n")
+	if err := gauth.WriteConfigFile(path, []byte("x"), want); err != nil {
+		t.Fatalf("WriteConfigFile: unexpected error: %v", err)
+	}
+
+	// Only the PBKDF2 scheme can read the result back.
+	defer func(saved []gauth.Encryption) { gauth.Encryptions = saved }(gauth.Encryptions)
+	gauth.Encryptions = []gauth.Encryption{gauth.PBKDF2Encryption}
+	got, err := gauth.LoadConfigFile(path, func() ([]byte, error) { return []byte("x"), nil })
+	if err != nil {
+		t.Fatalf("Loading rewritten config: %v", err)
+	} else if !bytes.Equal(got, want) {
+		t.Errorf("Rewritten config: got %q, want %q", got, want)
+	}
+}
+
+func TestParseEncryption(t *testing.T) {
+	tests := []struct {
+		input string
+		want  gauth.Encryption
+		fail  bool
+	}{
+		{"-aes-128-cbc -md sha256", gauth.LegacyEncryption, false},
+		{"-aes-256-cbc -pbkdf2", gauth.PBKDF2Encryption, false},
+		{"-pbkdf2 -iter 600000", gauth.Encryption{KeySize: 32, Iter: 600000}, false},
+		{"aes-192-cbc iter 5", gauth.Encryption{KeySize: 24, Iter: 5}, false},
+		{"-aes-256-cbc -md md5", gauth.Encryption{}, true},
+		{"-iter lots", gauth.Encryption{}, true},
+		{"-des", gauth.Encryption{}, true},
+	}
+	for _, test := range tests {
+		got, err := gauth.ParseEncryption(test.input)
+		if err != nil && !test.fail {
+			t.Errorf("ParseEncryption(%q): unexpected error: %v", test.input, err)
+		} else if err == nil && test.fail {
+			t.Errorf("ParseEncryption(%q): got %+v, want error", test.input, got)
+		} else if got != test.want {
+			t.Errorf("ParseEncryption(%q): got %+v, want %+v", test.input, got, test.want)
+		}
+		if err == nil {
+			if back, err := gauth.ParseEncryption(got.String()); err != nil || back != got {
+				t.Errorf("ParseEncryption(%q): got %+v, %v", got.String(), back, err)
+			}
+		}
+	}
+}
+
+func TestNativeVault(t *testing.T) {
+	params := gauth.ScryptParams{LogN: 10, R: 8, P: 1}
+	plain := []byte("test1:AAAQEAYEAUDAOCAJ\n")
+	vault, err := gauth.EncryptNative(params, []byte("x"), plain)
+	if err != nil {
+		t.Fatalf("EncryptNative: unexpected error: %v", err)
+	}
+	if !gauth.IsNative(vault) {
+		t.Error("IsNative on a native vault: got false")
+	}
+	if gauth.IsNative(plain) {
+		t.Error("IsNative on plaintext: got true")
+	}
+
+	got, err := gauth.DecryptNative(vault, []byte("x"))
+	if err != nil {
+		t.Fatalf("DecryptNative: unexpected error: %v", err)
+	} else if !bytes.Equal(got, plain) {
+		t.Errorf("DecryptNative: got %q, want %q", got, plain)
+	}
+
+	if _, err := gauth.DecryptNative(vault, []byte("y")); err == nil {
+		t.Error("DecryptNative with the wrong password: got nil error")
+	}
+	for _, i := range []int{9, len(vault) - 1} {
+		tampered := bytes.Clone(vault)
+		tampered[i] ^= 1
+		if _, err := gauth.DecryptNative(tampered, []byte("x")); err == nil {
+			t.Errorf("DecryptNative with byte %d modified: got nil error", i)
+		}
+	}
+}
+
+func TestMigrateConfigFile(t *testing.T) {
+	defer func(saved gauth.ScryptParams) { gauth.DefaultScryptParams = saved }(gauth.DefaultScryptParams)
+	gauth.DefaultScryptParams = gauth.ScryptParams{LogN: 10, R: 8, P: 1}
+
+	orig, err := os.ReadFile("testdata/encrypted.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "gauth.csv")
+	if err := os.WriteFile(path, orig, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gauth.MigrateConfigFile(path, []byte("wrong")); err == nil {
+		t.Error("MigrateConfigFile with the wrong password: got nil error")
+	}
+	if err := gauth.MigrateConfigFile(path, []byte("x")); err != nil {
+		t.Fatalf("MigrateConfigFile: unexpected error: %v", err)
+	}
+	if err := gauth.MigrateConfigFile(path, []byte("x")); err == nil {
+		t.Error("MigrateConfigFile on a native vault: got nil error")
+	}
+
+	getPass := func() ([]byte, error) { return []byte("x"), nil }
+	want, err := gauth.LoadConfigFile("testdata/plaintext.csv", getPass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := gauth.LoadConfigFile(path, getPass)
+	if err != nil {
+		t.Fatalf("Loading migrated config: %v", err)
+	} else if !bytes.Equal(got, want) {
+		t.Errorf("Migrated config: got %q, want %q", got, want)
+	}
+
+	// Edits keep the native format.
+	update := []byte("updated:ABCDEFGH\n")
+	if err := gauth.WriteConfigFile(path, []byte("x"), update); err != nil {
+		t.Fatalf("WriteConfigFile: unexpected error: %v", err)
+	}
+	data, _, err := gauth.ReadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := gauth.DecryptNative(data, []byte("x")); err != nil || !bytes.Equal(got, update) {
+		t.Errorf("Rewritten native vault: got %q, %v, want %q", got, err, update)
+	}
+}
+
+func TestPasswordCommands(t *testing.T) {
+	defer func(saved gauth.ScryptParams) { gauth.DefaultScryptParams = saved }(gauth.DefaultScryptParams)
+	gauth.DefaultScryptParams = gauth.ScryptParams{LogN: 10, R: 8, P: 1}
+
+	plain, err := os.ReadFile("testdata/plaintext.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "gauth.csv")
+	if err := os.WriteFile(path, plain, 0600); err != nil {
+		t.Fatal(err)
+	}
+	load := func(pass string) ([]byte, error) {
+		return gauth.LoadConfigFile(path, func() ([]byte, error) { return []byte(pass), nil })
+	}
+
+	if err := gauth.DecryptConfigFile(path, []byte("x")); err == nil {
+		t.Error("DecryptConfigFile on plaintext: got nil error")
+	}
+	// Leave a plaintext backup behind, which encrypting must remove.
+	if err := gauth.WriteConfigFile(path, nil, plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := gauth.EncryptConfigFile(path, []byte("x")); err != nil {
+		t.Fatalf("EncryptConfigFile: unexpected error: %v", err)
+	}
+	if backups, err := gauth.ListBackups(path); err != nil || len(backups) != 0 {
+		t.Errorf("Backups after encrypting: got %+v, %v, want none", backups, err)
+	}
+	if err := gauth.EncryptConfigFile(path, []byte("x")); err == nil {
+		t.Error("EncryptConfigFile on an encrypted config: got nil error")
+	}
+	if got, err := load("x"); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Encrypted config: got %q, %v, want %q", got, err, plain)
+	}
+
+	if err := gauth.ChangePassword(path, []byte("wrong"), []byte("y")); err == nil {
+		t.Error("ChangePassword with the wrong password: got nil error")
+	}
+	if err := gauth.ChangePassword(path, []byte("x"), []byte("y")); err != nil {
+		t.Fatalf("ChangePassword: unexpected error: %v", err)
+	}
+	if _, err := load("x"); err == nil {
+		t.Error("Loading with the old password: got nil error")
+	}
+	if got, err := load("y"); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Config with new password: got %q, %v, want %q", got, err, plain)
+	}
+
+	if err := gauth.DecryptConfigFile(path, []byte("y")); err != nil {
+		t.Fatalf("DecryptConfigFile: unexpected error: %v", err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Decrypted config: got %q, %v, want %q", got, err, plain)
+	}
+}
+
+func TestChangePasswordBackups(t *testing.T) {
+	defer func(saved gauth.ScryptParams) { gauth.DefaultScryptParams = saved }(gauth.DefaultScryptParams)
+	gauth.DefaultScryptParams = gauth.ScryptParams{LogN: 10, R: 8, P: 1}
+
+	path := filepath.Join(t.TempDir(), "gauth.csv")
+	if err := os.WriteFile(path, []byte("v1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := gauth.EncryptConfigFile(path, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"v2\n", "v3\n"} {
+		if err := gauth.WriteConfigFile(path, []byte("x"), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A backup from an even older password, which is deleted.
+	stale, err := gauth.EncryptNative(gauth.DefaultScryptParams, []byte("w"), []byte("v0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".bak.5", stale, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gauth.ChangePassword(path, []byte("x"), []byte("y")); err != nil {
+		t.Fatalf("ChangePassword: unexpected error: %v", err)
+	}
+	backups, err := gauth.ListBackups(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("Backups after ChangePassword: got %d, want 3", len(backups))
+	}
+	for i, want := range []string{"v3\n", "v2\n", "v1\n"} {
+		b := backups[i]
+		if _, err := gauth.LoadConfigFile(b.Path, func() ([]byte, error) { return []byte("x"), nil }); err == nil {
+			t.Errorf("Backup %d decrypts with the old password", b.Index)
+		}
+		got, err := gauth.LoadConfigFile(b.Path, func() ([]byte, error) { return []byte("y"), nil })
+		if err != nil || string(got) != want {
+			t.Errorf("Backup %d with the new password: got %q, %v, want %q", b.Index, got, err, want)
+		}
+	}
+
+	// Restoring a backup keeps the new password.
+	if err := gauth.RestoreBackup(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := gauth.LoadConfigFile(path, func() ([]byte, error) { return []byte("y"), nil }); err != nil || string(got) != "v2\n" {
+		t.Errorf("Restored config: got %q, %v, want %q", got, err, "v2\n")
+	}
+}
+
+func TestWriteConfigFreshSalt(t *testing.T) {
+	orig, err := os.ReadFile("testdata/encrypted.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "gauth.csv")
+	if err := os.WriteFile(path, orig, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gauth.ChangePassword(path, []byte("x"), []byte("x")); err != nil {
+		t.Fatalf("ChangePassword: unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got[8:16], orig[8:16]) {
+		t.Errorf("Rewritten config reuses salt %x", orig[8:16])
+	}
+}
+
+func TestBackups(t *testing.T) {
+	defer func(saved int) { gauth.BackupCount = saved }(gauth.BackupCount)
+	gauth.BackupCount = 2
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gauth.csv")
+	for _, v := range []string{"v1\n", "v2\n", "v3\n", "v4\n"} {
+		if err := gauth.WriteConfigFile(path, nil, []byte(v)); err != nil {
+			t.Fatalf("WriteConfigFile(%q): unexpected error: %v", v, err)
+		}
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	backups, err := gauth.ListBackups(path)
+	if err != nil {
+		t.Fatalf("ListBackups: unexpected error: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("ListBackups: got %d backups, want 2", len(backups))
+	}
+	for i, want := range []string{"v3\n", "v2\n"} {
+		if b := backups[i]; b.Index != i+1 || read(b.Path) != want {
+			t.Errorf("Backup %d: got index %d with %q, want %q", i+1, b.Index, read(b.Path), want)
+		}
+	}
+
+	if err := gauth.RestoreBackup(path, 2); err != nil {
+		t.Fatalf("RestoreBackup: unexpected error: %v", err)
+	}
+	if got := read(path); got != "v2\n" {
+		t.Errorf("Restored config: got %q, want %q", got, "v2\n")
+	}
+	if got := read(backups[0].Path); got != "v4\n" {
+		t.Errorf("Backup of the replaced config: got %q, want %q", got, "v4\n")
+	}
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("Config directory: got %q, want the config and 2 backups", names)
+	}
+}
+
+func TestLockConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gauth.csv")
+	lock, err := gauth.LockConfigFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("LockConfigFile: unexpected error: %v", err)
+	}
+
+	_, err = gauth.LockConfigFile(path, 200*time.Millisecond)
+	if err == nil {
+		t.Fatal("LockConfigFile on a locked config: got nil error")
+	}
+	if want := fmt.Sprintf("process %d", os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("LockConfigFile on a locked config: got %q, want it to mention %q", err, want)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock: unexpected error: %v", err)
+	}
+	lock, err = gauth.LockConfigFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("LockConfigFile after Unlock: unexpected error: %v", err)
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,105 @@
+package gauth
+
+import (
+	"fmt"
+
+	"github.com/creachadair/otp/otpauth"
+	"github.com/pcarrier/gauth/internal/sqlite"
+)
+
+// Google Authenticator keeps its accounts in an SQLite database, at
+// /data/data/com.google.android.apps.authenticator2/databases/database on
+// Android, in a table created as:
+//
+//	CREATE TABLE accounts (_id INTEGER PRIMARY KEY, email TEXT NOT NULL,
+//	    secret TEXT NOT NULL, counter INTEGER DEFAULT 0, type INTEGER,
+//	    provider INTEGER DEFAULT 0, issuer TEXT DEFAULT NULL,
+//	    original_name TEXT DEFAULT NULL)
+//
+// where email is the name of the account, and type is 0 for TOTP and 1 for
+// HOTP. Older versions have no issuer column.
+
+// IsSQLite reports whether data is an SQLite database, such as that of
+// Google Authenticator.
+func IsSQLite(data []byte) bool {
+	return sqlite.IsSQLite(data)
+}
+
+// IsSQLiteWAL reports whether data is an SQLite database in write-ahead log
+// mode, as that of Google Authenticator usually is. Its latest changes may
+// only be in the -wal file next to it, which ParseGoogleAuthenticatorDB needs
+// to see them.
+func IsSQLiteWAL(data []byte) bool {
+	return sqlite.IsWAL(data)
+}
+
+// ParseGoogleAuthenticatorDB returns the accounts of the Google
+// Authenticator database in data, including the changes still in its
+// write-ahead log wal, the database-wal file next to it. Without wal, which
+// may be nil, accounts added or changed since the last checkpoint of a
+// database in write-ahead log mode are missing or out of date.
+func ParseGoogleAuthenticatorDB(data, wal []byte) ([]Account, []ImportFailure, error) {
+	db, err := sqlite.Open(data, wal)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns, rows, err := db.Table("accounts")
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a Google Authenticator database: %v", err)
+	}
+	index := make(map[string]int)
+	for i, c := range columns {
+		index[c] = i
+	}
+	for _, c := range []string{"email", "secret"} {
+		if _, ok := index[c]; !ok {
+			return nil, nil, fmt.Errorf("not a Google Authenticator database: no %s column", c)
+		}
+	}
+	text := func(row []any, column string) string {
+		i, ok := index[column]
+		if !ok {
+			return ""
+		}
+		s, _ := row[i].(string)
+		return s
+	}
+	integer := func(row []any, column string) int64 {
+		i, ok := index[column]
+		if !ok {
+			return 0
+		}
+		n, _ := row[i].(int64)
+		return n
+	}
+
+	var accounts []Account
+	var failed []ImportFailure
+	for _, row := range rows {
+		u := &otpauth.URL{
+			Issuer:    text(row, "issuer"),
+			Account:   text(row, "email"),
+			RawSecret: text(row, "secret"),
+		}
+		switch typ := integer(row, "type"); typ {
+		case 0:
+			u.Type = "totp"
+		case 1:
+			u.Type = "hotp"
+			// Google Authenticator stores the counter of the last code it
+			// showed, while gauth stores that of the next one.
+			u.Counter = uint64(max(integer(row, "counter"), 0)) + 1
+		default:
+			u.Type = "unknown"
+			failed = append(failed, ImportFailure{URL: u, Err: fmt.Errorf("unsupported Google Authenticator account type %d", typ)})
+			continue
+		}
+		parsed, err := reparse(u)
+		if err != nil {
+			failed = append(failed, ImportFailure{URL: u, Err: err})
+			continue
+		}
+		accounts = append(accounts, Account{URL: parsed})
+	}
+	return accounts, failed, nil
+}
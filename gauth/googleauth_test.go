@@ -0,0 +1,51 @@
+package gauth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pcarrier/gauth/gauth"
+)
+
+func TestParseGoogleAuthenticatorDB(t *testing.T) {
+	data, err := os.ReadFile("testdata/google-authenticator.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gauth.IsSQLite(data) {
+		t.Fatal("IsSQLite: got false")
+	}
+	accounts, failed, err := gauth.ParseExport(data, noPassword)
+	if err != nil {
+		t.Fatalf("ParseExport: unexpected error: %v", err)
+	}
+	if len(accounts) != 3 || len(failed) != 0 {
+		t.Fatalf("ParseExport: got %d accounts and %d failures, want 3 and 0", len(accounts), len(failed))
+	}
+	for i, want := range []struct {
+		typ, issuer, account string
+		counter              uint64
+	}{
+		{"totp", "Example", "alice@example.com", 0},
+		{"hotp", "Corp", "vpn", 6}, // 5 in the database
+		{"totp", "", "Github", 0},
+	} {
+		u := accounts[i].URL
+		if u.Type != want.typ || u.Issuer != want.issuer || u.Account != want.account || u.Counter != want.counter {
+			t.Errorf("ParseExport: got %+v, want %s account %s:%s with counter %d", u, want.typ, want.issuer, want.account, want.counter)
+		}
+		if _, err := u.Secret(); err != nil {
+			t.Errorf("Secret(%s): %v", u.Account, err)
+		}
+	}
+
+	// The secret of vpn is that of RFC 4226, whose appendix D gives the
+	// code for counter 6. Google Authenticator already showed that of 5.
+	if code, err := gauth.NextHOTP(accounts[1].URL); err != nil || code != "287922" {
+		t.Errorf("NextHOTP(vpn) = %q, %v; want 287922", code, err)
+	}
+
+	if _, _, err := gauth.ParseGoogleAuthenticatorDB(data[:4096], nil); err == nil {
+		t.Error("ParseGoogleAuthenticatorDB of a truncated database: got nil error")
+	}
+}
@@ -0,0 +1,208 @@
+package gauth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/creachadair/otp/otpauth"
+)
+
+// An Account is an account exported from or to another authenticator app,
+// with its trailing comment in the config.
+type Account struct {
+	URL     *otpauth.URL
+	Comment string
+}
+
+// Accounts returns the accounts of v with their comments, in the order of
+// the file.
+func (v *Vault) Accounts() []Account {
+	var out []Account
+	for _, u := range v.URLs() {
+		out = append(out, Account{URL: u, Comment: v.Comment(u)})
+	}
+	return out
+}
+
+// ImportReport describes what Vault.Import did with each account.
+type ImportReport struct {
+	Added      []*otpauth.URL  // accounts added to the vault
+	Duplicates []*otpauth.URL  // skipped, the vault has an account with the same secret
+	Collisions []*otpauth.URL  // skipped, the vault has another account with the same name
+	Failed     []ImportFailure // skipped, not usable by gauth
+}
+
+// An ImportFailure is an account that could not be imported.
+type ImportFailure struct {
+	URL *otpauth.URL
+	Err error
+}
+
+// Import adds urls to v, checking each as Add does. Accounts whose secret is
+// already in the vault are skipped, and so are accounts whose issuer and name
+// are already used by an account with a different secret.
+func (v *Vault) Import(urls []*otpauth.URL) ImportReport {
+	accounts := make([]Account, len(urls))
+	for i, u := range urls {
+		accounts[i].URL = u
+	}
+	return v.ImportAccounts(accounts)
+}
+
+// ImportAccounts is like Import, and also sets the comments of the accounts
+// it adds.
+func (v *Vault) ImportAccounts(accounts []Account) ImportReport {
+	var report ImportReport
+	for _, a := range accounts {
+		u := a.URL
+		if err := checkURL(u); err != nil {
+			report.Failed = append(report.Failed, ImportFailure{URL: u, Err: err})
+			continue
+		}
+		if v.hasSecret(u) {
+			report.Duplicates = append(report.Duplicates, u)
+			continue
+		}
+		if v.exists(u.Issuer, u.Account, nil) {
+			report.Collisions = append(report.Collisions, u)
+			continue
+		}
+		if err := v.Add(u); err != nil {
+			report.Failed = append(report.Failed, ImportFailure{URL: u, Err: err})
+			continue
+		}
+		if a.Comment != "" {
+			// Comments cannot span lines; keep what they say on one.
+			comment := strings.Join(strings.Fields(a.Comment), " ")
+			if err := v.SetComment(u, comment); err != nil {
+				report.Failed = append(report.Failed, ImportFailure{URL: u, Err: err})
+				_ = v.Remove(u)
+				continue
+			}
+		}
+		report.Added = append(report.Added, u)
+	}
+	return report
+}
+
+// hasSecret reports whether v has an account with the same secret as u.
+func (v *Vault) hasSecret(u *otpauth.URL) bool {
+	secret, err := u.Secret()
+	if err != nil {
+		return false
+	}
+	for _, w := range v.URLs() {
+		if s, err := w.Secret(); err == nil && bytes.Equal(s, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseExport returns the accounts in data, which may be an export of another
+// authenticator app in any format gauth knows, or text holding otpauth:// and
+// otpauth-migration:// URLs as ParseURLs reads them. The getPass function is
+// called to obtain a password if the export is encrypted. Encrypted andOTP
+// backups cannot be told apart from other binary data and are reported as
+// unrecognized; read them with ParseAndOTP. SQLite databases are read
+// without their write-ahead log, so callers that have the -wal file of a
+// database for which IsSQLiteWAL is true should pass both to
+// ParseGoogleAuthenticatorDB instead. Accounts that cannot be represented as
+// otpauth URLs are returned as failures.
+func ParseExport(data []byte, getPass func() ([]byte, error)) ([]Account, []ImportFailure, error) {
+	if IsAegis(data) {
+		return ParseAegis(data, getPass)
+	}
+	if IsBitwarden(data) {
+		return ParseBitwarden(data, getPass)
+	}
+	if Is2FAS(data) {
+		return Parse2FAS(data, getPass)
+	}
+	if IsKDBX(data) {
+		return ParseKDBX(data, getPass)
+	}
+	if IsSQLite(data) {
+		return ParseGoogleAuthenticatorDB(data, nil)
+	}
+	if IsAndOTP(data) {
+		return ParseAndOTP(data, getPass)
+	}
+	if IsFreeOTPPlus(data) {
+		return ParseFreeOTPPlus(data)
+	}
+	if IsFreeOTP(data) {
+		return ParseFreeOTP(data)
+	}
+	if IsPAM(data) {
+		a, err := ParsePAM(data, DefaultPAMName)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []Account{a}, nil, nil
+	}
+	if !utf8.Valid(data) {
+		return nil, nil, errors.New("unrecognized export format")
+	}
+	urls, err := ParseURLs(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	accounts := make([]Account, len(urls))
+	for i, u := range urls {
+		accounts[i].URL = u
+	}
+	return accounts, nil, nil
+}
+
+// ParseURLs returns the accounts described by the otpauth:// and
+// otpauth-migration:// URLs in text, which may hold any number of them
+// separated by whitespace. Google Authenticator exports are
+// otpauth-migration:// URLs, each holding a batch of accounts.
+func ParseURLs(text string) ([]*otpauth.URL, error) {
+	var out []*otpauth.URL
+	for _, field := range strings.Fields(text) {
+		switch {
+		case strings.HasPrefix(field, "otpauth-migration://"):
+			urls, err := otpauth.ParseMigrationURL(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid migration URL: %v", err)
+			}
+			out = append(out, urls...)
+		case strings.HasPrefix(field, "otpauth://"):
+			u, err := otpauth.ParseURL(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid otpauth URL: %v", err)
+			}
+			out = append(out, u)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no otpauth or otpauth-migration URLs found")
+	}
+	return out, nil
+}
+
+// reparse returns u as the config would read it back, so that imported
+// accounts get the same defaults and checks as those in the config.
+func reparse(u *otpauth.URL) (*otpauth.URL, error) {
+	return parseAccount(u.String())
+}
+
+// openGCM decrypts ciphertext, followed by its tag, with AES-GCM.
+func openGCM(key, nonce, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,79 @@
+package gauth_test
+
+import (
+	"testing"
+
+	"github.com/creachadair/otp/otpauth"
+	"github.com/pcarrier/gauth/gauth"
+)
+
+// testMigrationURL holds three accounts: Example:alice@example.com (TOTP),
+// Corp:vpn (HOTP, SHA512, 8 digits, counter 5) and copy, which has the same
+// secret as the first one.
+const testMigrationURL = "otpauth-migration://offline?data=Ci4KCkhlbGxvId6tvu8SEWFsaWNlQGV4YW1wbGUuY29tGgdF" +
+	"eGFtcGxlIAEoATACCikKFDEyMzQ1Njc4OTAxMjM0NTY3ODkwEgN2cG4aBENvcnAgAygCMAE4BQoaCgpIZWxsbyHerb7vEgRjb3B5GgAgASgBMAI%3D"
+
+func TestParseURLs(t *testing.T) {
+	urls, err := gauth.ParseURLs("\n" + testMigrationURL + "\n otpauth://totp/web?secret=ABCDEFGH\n")
+	if err != nil {
+		t.Fatalf("ParseURLs: unexpected error: %v", err)
+	}
+	var names []string
+	for _, u := range urls {
+		names = append(names, u.Account)
+	}
+	if len(urls) != 4 {
+		t.Fatalf("ParseURLs: got %q, want 4 accounts", names)
+	}
+	vpn := urls[1]
+	if vpn.Type != "hotp" || vpn.Issuer != "Corp" || vpn.Algorithm != "SHA512" || vpn.Digits != 8 || vpn.Counter != 5 {
+		t.Errorf("ParseURLs: got %+v, want Corp HOTP account with SHA512, 8 digits and counter 5", vpn)
+	}
+
+	if _, err := gauth.ParseURLs("nothing to see"); err == nil {
+		t.Error("ParseURLs without URLs: got nil error")
+	}
+	if _, err := gauth.ParseURLs("otpauth-migration://offline?data=!!!"); err == nil {
+		t.Error("ParseURLs with an invalid migration URL: got nil error")
+	}
+}
+
+func TestImport(t *testing.T) {
+	v, err := gauth.ParseVault([]byte("web:ABCDEFGH\notpauth://totp/Example:alice@example.com?secret=AAAAAAAA\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := gauth.ParseURLs(testMigrationURL + " otpauth://totp/web2?secret=ABCDEFGH" +
+		" otpauth://totp/old?secret=ABCDEFGH&algorithm=MD5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := v.Import(urls)
+	check := func(what string, got []*otpauth.URL, want ...string) {
+		t.Helper()
+		var names []string
+		for _, u := range got {
+			names = append(names, u.Account)
+		}
+		if len(names) != len(want) {
+			t.Errorf("%s: got %q, want %q", what, names, want)
+			return
+		}
+		for i := range names {
+			if names[i] != want[i] {
+				t.Errorf("%s: got %q, want %q", what, names, want)
+				return
+			}
+		}
+	}
+	check("Added", report.Added, "vpn", "copy")
+	check("Duplicates", report.Duplicates, "web2")
+	check("Collisions", report.Collisions, "alice@example.com")
+	if len(report.Failed) != 1 || report.Failed[0].URL.Account != "old" {
+		t.Errorf("Failed: got %+v, want old", report.Failed)
+	}
+	if n := len(v.URLs()); n != 4 {
+		t.Errorf("Vault after import: got %d accounts, want 4", n)
+	}
+}
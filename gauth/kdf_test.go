@@ -0,0 +1,26 @@
+package gauth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestArgon2d(t *testing.T) {
+	// Test vector from RFC 9106 section 5.1.
+	password := bytes.Repeat([]byte{1}, 32)
+	salt := bytes.Repeat([]byte{2}, 16)
+	secret := bytes.Repeat([]byte{3}, 8)
+	data := bytes.Repeat([]byte{4}, 12)
+	want := "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"
+	got, err := argon2d(password, salt, secret, data, 3, 32, 4, 32)
+	if err != nil {
+		t.Errorf("argon2d: unexpected error: %v", err)
+	} else if hex.EncodeToString(got) != want {
+		t.Errorf("argon2d: got %x, want %s", got, want)
+	}
+
+	if _, err := argon2d(nil, nil, nil, nil, 1, 4, 1, 32); err == nil {
+		t.Error("argon2d with 4 KiB: got nil error")
+	}
+}
@@ -0,0 +1,120 @@
+package gauth_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"testing"
+
+	"github.com/creachadair/otp/otpauth"
+	"github.com/pcarrier/gauth/gauth"
+)
+
+// testdata/keepassxc.kdbx uses Argon2d, ChaCha20, gzip and a ChaCha20 inner
+// stream, and testdata/keepassxc-aes.kdbx AES-KDF, AES-256 and a Salsa20
+// inner stream. Both have the password "correct horse", and hold:
+//
+//	Root   GitHub/alice  otp, and an older otp in its history
+//	Root   Steam         otp with encoder=steam, no user name
+//	Root   Legacy/bob    TOTP Seed and TOTP Settings 60;8
+//	Root   No OTP/carol
+//	Work   AWS/root      otp with SHA256 and 8 digits
+//	Work   KeeOtp/dave   otp in KeeOtp's format, 8 digits every 45 seconds
+//	Recycle Bin  Deleted/eve  otp
+
+func TestParseKDBX(t *testing.T) {
+	getPass := func() ([]byte, error) { return []byte("correct horse"), nil }
+	for _, file := range []string{"testdata/keepassxc.kdbx", "testdata/keepassxc-aes.kdbx"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !gauth.IsKDBX(data) {
+			t.Fatalf("IsKDBX(%s): got false", file)
+		}
+		accounts, failed, err := gauth.ParseKDBX(data, getPass)
+		if err != nil {
+			t.Fatalf("ParseKDBX(%s): unexpected error: %v", file, err)
+		}
+		if len(accounts) != 5 || len(failed) != 0 {
+			t.Fatalf("ParseKDBX(%s): got %d accounts and %d failures, want 5 and 0", file, len(accounts), len(failed))
+		}
+		for i, want := range []struct {
+			url     otpauth.URL
+			comment string
+		}{
+			{otpauth.URL{Type: "totp", Issuer: "GitHub", Account: "alice", RawSecret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA1", Digits: 6, Period: 30}, ""},
+			{otpauth.URL{Type: "steam", Account: "Steam", RawSecret: "ABCDEFGHIJKLMNOP", Algorithm: "SHA1", Digits: 6, Period: 30}, ""},
+			{otpauth.URL{Type: "totp", Issuer: "Legacy", Account: "bob", RawSecret: "GEZDGNBVGY3TQOJQ", Algorithm: "SHA1", Digits: 8, Period: 60}, ""},
+			{otpauth.URL{Type: "totp", Issuer: "AWS", Account: "root", RawSecret: "ABCDEFGHIJKLMNOP", Algorithm: "SHA256", Digits: 8, Period: 30}, "[Work]"},
+			{otpauth.URL{Type: "totp", Issuer: "KeeOtp", Account: "dave", RawSecret: "MFRGGZDFMZTWQ2LK", Algorithm: "SHA1", Digits: 8, Period: 45}, "[Work]"},
+		} {
+			if got := accounts[i]; *got.URL != want.url || got.Comment != want.comment {
+				t.Errorf("ParseKDBX(%s): got %+v %q, want %+v %q", file, *got.URL, got.Comment, want.url, want.comment)
+			}
+		}
+
+		if _, _, err := gauth.ParseKDBX(data, func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+			t.Errorf("ParseKDBX(%s) with a wrong password: got nil error", file)
+		}
+		corrupted := bytes.Clone(data)
+		corrupted[len(corrupted)-100] ^= 1
+		if _, _, err := gauth.ParseKDBX(corrupted, getPass); err == nil {
+			t.Errorf("ParseKDBX(%s) of a corrupted database: got nil error", file)
+		}
+	}
+}
+
+// testdata/keepass-argon2d.kdbx (AES-256) and
+// testdata/keepass-argon2d-chacha20.kdbx (ChaCha20) were saved by KeePass 2
+// with Argon2d, and come from the tests of github.com/tobischo/gokeepasslib
+// (MIT license). Their password is "abcdefg12345678", and none of their
+// entries has an OTP. The header HMAC only verifies with the right Argon2d
+// key, so parsing them checks the Argon2d implementation.
+func TestParseKDBXArgon2d(t *testing.T) {
+	for _, file := range []string{"testdata/keepass-argon2d.kdbx", "testdata/keepass-argon2d-chacha20.kdbx"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts, failed, err := gauth.ParseKDBX(data, func() ([]byte, error) { return []byte("abcdefg12345678"), nil })
+		if err != nil || len(accounts) != 0 || len(failed) != 0 {
+			t.Errorf("ParseKDBX(%s) = %d accounts, %d failures, %v; want none and no error", file, len(accounts), len(failed), err)
+		}
+		if _, _, err := gauth.ParseKDBX(data, func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+			t.Errorf("ParseKDBX(%s) with a wrong password: got nil error", file)
+		}
+	}
+}
+
+func TestExportKeePassXCCSV(t *testing.T) {
+	accounts := []gauth.Account{
+		{URL: &otpauth.URL{Type: "totp", Issuer: "GitHub", Account: "alice", RawSecret: "JBSWY3DPEHPK3PXP"}, Comment: "[Work, Home] laptop"},
+		{URL: &otpauth.URL{Type: "steam", Account: "gamer", RawSecret: "ABCDEFGH"}},
+	}
+	data, err := gauth.ExportKeePassXCCSV(accounts)
+	if err != nil {
+		t.Fatalf("ExportKeePassXCCSV: unexpected error: %v", err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("ExportKeePassXCCSV: invalid CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("ExportKeePassXCCSV: got %d records, want a header and 2 accounts", len(records))
+	}
+	github, steam := records[1], records[2]
+	if github[0] != "Root/Work" || github[1] != "GitHub" || github[2] != "alice" || github[5] != "laptop" ||
+		github[6] != "otpauth://totp/GitHub:alice?issuer=GitHub&secret=JBSWY3DPEHPK3PXP" {
+		t.Errorf("ExportKeePassXCCSV: got %q for GitHub", github)
+	}
+	if steam[0] != "Root" || steam[1] != "gamer" || steam[2] != "" ||
+		steam[6] != "otpauth://totp/gamer?digits=5&secret=ABCDEFGH&encoder=steam" {
+		t.Errorf("ExportKeePassXCCSV: got %q for Steam", steam)
+	}
+
+	hotp := []gauth.Account{{URL: &otpauth.URL{Type: "hotp", Account: "vpn", RawSecret: "ABCDEFGH"}}}
+	if _, err := gauth.ExportKeePassXCCSV(hotp); err == nil {
+		t.Error("ExportKeePassXCCSV of an HOTP account: got nil error")
+	}
+}
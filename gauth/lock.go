@@ -0,0 +1,74 @@
+package gauth
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const lockPollInterval = 100 * time.Millisecond
+
+// A Lock is an advisory lock on a config file, held by LockConfigFile until
+// Unlock is called. It only excludes other processes that take the lock, so
+// every read-modify-write cycle of a config should hold it.
+type Lock struct {
+	f *os.File
+}
+
+// LockConfigFile takes an exclusive lock on the config at path, waiting up to
+// timeout for other processes to release it. The lock is held on path.lock,
+// since the config itself is replaced on every write; the operating system
+// releases it if the process dies. The error for a timeout names the process
+// that holds the lock.
+func LockConfigFile(path string, timeout time.Duration) (*Lock, error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %v", path, err)
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			holder := lockHolder(f)
+			f.Close()
+			return nil, fmt.Errorf("%s is locked by %s (gave up after %v)", path, holder, timeout)
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	// Record who holds the lock, for the error above.
+	host, _ := os.Hostname()
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(fmt.Sprintf("process %d on %s\n", os.Getpid(), host)), 0)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases l.
+func (l *Lock) Unlock() error {
+	l.f.Truncate(0)
+	err := unlock(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// lockHolder describes the process holding the lock on f.
+func lockHolder(f *os.File) string {
+	data, _ := io.ReadAll(io.NewSectionReader(f, 0, 1024))
+	if holder := strings.TrimSpace(string(data)); holder != "" {
+		return holder
+	}
+	return "another process"
+}
@@ -0,0 +1,155 @@
+package gauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/creachadair/otp/otpauth"
+)
+
+// Fields of the protocol buffer messages in otpauth-migration:// URLs, which
+// otpauth.ParseMigrationURL documents.
+const (
+	migrationParams     = 1
+	migrationVersion    = 2
+	migrationBatchSize  = 3
+	migrationBatchIndex = 4
+	migrationBatchID    = 5
+
+	paramsSecret    = 1
+	paramsAccount   = 2
+	paramsIssuer    = 3
+	paramsAlgorithm = 4
+	paramsDigits    = 5
+	paramsType      = 6
+	paramsCounter   = 7
+)
+
+// CheckMigration reports an error if u cannot be exported to Google
+// Authenticator, which only knows TOTP with a 30 seconds period and HOTP,
+// with 6 or 8 digits.
+func CheckMigration(u *otpauth.URL) error {
+	if _, err := migrationParamsOf(u); err != nil {
+		return err
+	}
+	return nil
+}
+
+// MigrationURLs encodes urls as otpauth-migration:// URLs holding at most
+// batchSize accounts each, like the QR codes Google Authenticator exports.
+// It reports an error if CheckMigration rejects any of urls.
+func MigrationURLs(urls []*otpauth.URL, batchSize int) ([]string, error) {
+	if batchSize < 1 {
+		return nil, errors.New("batch size must be positive")
+	}
+	var params [][]byte
+	for _, u := range urls {
+		p, err := migrationParamsOf(u)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", u.Account, err)
+		}
+		params = append(params, p)
+	}
+	if len(params) == 0 {
+		return nil, errors.New("no accounts to export")
+	}
+
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("generating batch ID: %v", err)
+	}
+	batchID := uint64(binary.BigEndian.Uint32(id[:]) &^ (1 << 31))
+
+	batches := (len(params) + batchSize - 1) / batchSize
+	var out []string
+	for i := 0; i < batches; i++ {
+		var msg []byte
+		for _, p := range params[i*batchSize : min((i+1)*batchSize, len(params))] {
+			msg = appendBytesField(msg, migrationParams, p)
+		}
+		msg = appendVarintField(msg, migrationVersion, 1)
+		msg = appendVarintField(msg, migrationBatchSize, uint64(batches))
+		msg = appendVarintField(msg, migrationBatchIndex, uint64(i))
+		msg = appendVarintField(msg, migrationBatchID, batchID)
+		data := url.QueryEscape(base64.StdEncoding.EncodeToString(msg))
+		out = append(out, "otpauth-migration://offline?data="+data)
+	}
+	return out, nil
+}
+
+// migrationParamsOf encodes u as a Params message.
+func migrationParamsOf(u *otpauth.URL) ([]byte, error) {
+	var typ uint64
+	switch u.Type {
+	case "hotp":
+		typ = 1
+	case "totp":
+		typ = 2
+		if u.Period != 0 && u.Period != DefaultPeriod {
+			return nil, fmt.Errorf("unsupported period: %d seconds", u.Period)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type: %q", u.Type)
+	}
+
+	var algorithm uint64
+	switch strings.ToUpper(u.Algorithm) {
+	case "", "SHA1":
+		algorithm = 1
+	case "SHA256":
+		algorithm = 2
+	case "SHA512":
+		algorithm = 3
+	case "MD5":
+		algorithm = 4
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %q", u.Algorithm)
+	}
+
+	var digits uint64
+	switch u.Digits {
+	case 0, 6:
+		digits = 1
+	case 8:
+		digits = 2
+	default:
+		return nil, fmt.Errorf("unsupported number of digits: %d", u.Digits)
+	}
+
+	secret, err := u.Secret()
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %v", err)
+	}
+
+	var msg []byte
+	msg = appendBytesField(msg, paramsSecret, secret)
+	msg = appendBytesField(msg, paramsAccount, []byte(u.Account))
+	if u.Issuer != "" {
+		msg = appendBytesField(msg, paramsIssuer, []byte(u.Issuer))
+	}
+	msg = appendVarintField(msg, paramsAlgorithm, algorithm)
+	msg = appendVarintField(msg, paramsDigits, digits)
+	msg = appendVarintField(msg, paramsType, typ)
+	if typ == 1 {
+		msg = appendVarintField(msg, paramsCounter, u.Counter)
+	}
+	return msg, nil
+}
+
+// appendVarintField appends a protocol buffer varint field to msg.
+func appendVarintField(msg []byte, id int, v uint64) []byte {
+	msg = binary.AppendUvarint(msg, uint64(id)<<3)
+	return binary.AppendUvarint(msg, v)
+}
+
+// appendBytesField appends a length-delimited protocol buffer field to msg.
+func appendBytesField(msg []byte, id int, data []byte) []byte {
+	msg = binary.AppendUvarint(msg, uint64(id)<<3|2)
+	msg = binary.AppendUvarint(msg, uint64(len(data)))
+	return append(msg, data...)
+}
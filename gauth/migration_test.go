@@ -0,0 +1,52 @@
+package gauth_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/otp/otpauth"
+	"github.com/pcarrier/gauth/gauth"
+)
+
+func TestMigrationURLs(t *testing.T) {
+	want, err := gauth.ParseURLs(testMigrationURL + " otpauth://totp/web?secret=ABCDEFGH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := gauth.MigrationURLs(want, 3)
+	if err != nil {
+		t.Fatalf("MigrationURLs: unexpected error: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("MigrationURLs: got %d batches, want 2", len(migrations))
+	}
+	got, err := gauth.ParseURLs(strings.Join(migrations, "\n"))
+	if err != nil {
+		t.Fatalf("ParseURLs(MigrationURLs()): unexpected error: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("round trip: got %d accounts, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		ws, _ := w.Secret()
+		gs, _ := g.Secret()
+		if g.Type != w.Type || g.Issuer != w.Issuer || g.Account != w.Account || string(gs) != string(ws) ||
+			g.Algorithm != w.Algorithm || g.Digits != w.Digits || g.Counter != w.Counter {
+			t.Errorf("round trip: got %+v, want %+v", g, w)
+		}
+	}
+
+	for _, u := range []*otpauth.URL{
+		{Type: "steam", Account: "steam", RawSecret: "ABCDEFGH"},
+		{Type: "totp", Account: "slow", RawSecret: "ABCDEFGH", Period: 60},
+		{Type: "totp", Account: "long", RawSecret: "ABCDEFGH", Digits: 7},
+	} {
+		if err := gauth.CheckMigration(u); err == nil {
+			t.Errorf("CheckMigration(%s): got nil error", u.Account)
+		}
+		if _, err := gauth.MigrationURLs([]*otpauth.URL{u}, 1); err == nil {
+			t.Errorf("MigrationURLs(%s): got nil error", u.Account)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package gauth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// The native vault format authenticates its contents, unlike the OpenSSL
+// format, so a wrong password or a modified file is always detected:
+//
+//	offset  size  field
+//	0       6     magic "GAUTH\x00"
+//	6       1     format version (1)
+//	7       1     KDF identifier (1: scrypt)
+//	8       1     scrypt log2(N)
+//	9       4     scrypt r, big endian
+//	13      4     scrypt p, big endian
+//	17      16    KDF salt
+//	33      12    AES-GCM nonce
+//	45      ...   AES-256-GCM ciphertext and tag
+//
+// The whole header is passed to AES-GCM as additional data.
+const (
+	nativePrefix     = "GAUTH\x00"
+	nativeVersion    = 1
+	nativeKDFScrypt  = 1
+	nativeSaltSize   = 16
+	nativeNonceSize  = 12
+	nativeHeaderSize = len(nativePrefix) + 3 + 4 + 4 + nativeSaltSize + nativeNonceSize
+	nativeKeySize    = 32
+	maxScryptMemory  = 1 << 30
+)
+
+// ScryptParams are the cost parameters of the scrypt KDF protecting native
+// vaults. Scrypt needs about 128*2^LogN*R bytes of memory.
+type ScryptParams struct {
+	LogN uint8  // CPU/memory cost, as a power of two
+	R    uint32 // block size
+	P    uint32 // parallelization
+}
+
+// DefaultScryptParams are used for new native vaults. They require 64 MiB.
+var DefaultScryptParams = ScryptParams{LogN: 16, R: 8, P: 1}
+
+func (p ScryptParams) check() error {
+	if p.LogN < 1 || p.LogN > 30 || p.R == 0 || p.P == 0 || p.P > 16 {
+		return fmt.Errorf("invalid scrypt parameters %+v", p)
+	}
+	if uint64(p.R) > maxScryptMemory/(uint64(128)<<p.LogN) {
+		return fmt.Errorf("scrypt parameters %+v need too much memory", p)
+	}
+	return nil
+}
+
+// IsNative reports whether data looks like a native gauth vault.
+func IsNative(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(nativePrefix))
+}
+
+// EncryptNative encrypts config as a native vault, with a key derived from
+// passwd using params. A fresh salt and nonce are generated on every call.
+func EncryptNative(params ScryptParams, passwd, config []byte) ([]byte, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, nativeHeaderSize)
+	n := copy(header, nativePrefix)
+	header[n] = nativeVersion
+	header[n+1] = nativeKDFScrypt
+	header[n+2] = params.LogN
+	binary.BigEndian.PutUint32(header[n+3:], params.R)
+	binary.BigEndian.PutUint32(header[n+7:], params.P)
+	if _, err := rand.Read(header[n+11:]); err != nil {
+		return nil, fmt.Errorf("generating salt and nonce: %v", err)
+	}
+
+	aead, err := nativeAEAD(params, passwd, header)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, header[nativeHeaderSize-nativeNonceSize:], config, header), nil
+}
+
+// DecryptNative decrypts the native vault in data using passwd. It reports an
+// error if the password is wrong or the vault was modified.
+func DecryptNative(data, passwd []byte) ([]byte, error) {
+	params, err := nativeParams(data)
+	if err != nil {
+		return nil, err
+	}
+	header := data[:nativeHeaderSize]
+	aead, err := nativeAEAD(params, passwd, header)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, header[nativeHeaderSize-nativeNonceSize:], data[nativeHeaderSize:], header)
+	if err != nil {
+		return nil, errors.New("invalid password or corrupted vault")
+	}
+	return plain, nil
+}
+
+// nativeParams validates the header of the native vault in data and returns
+// the KDF parameters it holds.
+func nativeParams(data []byte) (ScryptParams, error) {
+	if !IsNative(data) {
+		return ScryptParams{}, errors.New("not a native vault")
+	}
+	if len(data) < nativeHeaderSize {
+		return ScryptParams{}, errors.New("native vault too short")
+	}
+	n := len(nativePrefix)
+	if v := data[n]; v != nativeVersion {
+		return ScryptParams{}, fmt.Errorf("unsupported vault version %d", v)
+	}
+	if kdf := data[n+1]; kdf != nativeKDFScrypt {
+		return ScryptParams{}, fmt.Errorf("unsupported vault KDF %d", kdf)
+	}
+	params := ScryptParams{
+		LogN: data[n+2],
+		R:    binary.BigEndian.Uint32(data[n+3:]),
+		P:    binary.BigEndian.Uint32(data[n+7:]),
+	}
+	return params, params.check()
+}
+
+// nativeAEAD derives the vault key from passwd and the salt in header.
+func nativeAEAD(params ScryptParams, passwd, header []byte) (cipher.AEAD, error) {
+	salt := header[nativeHeaderSize-nativeNonceSize-nativeSaltSize : nativeHeaderSize-nativeNonceSize]
+	key, err := scrypt.Key(passwd, salt, 1<<params.LogN, int(params.R), int(params.P), nativeKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// MigrateConfigFile converts the OpenSSL-encrypted config at path to the
+// native format, keeping the same password. The converted vault is checked
+// to decrypt to the original contents before it replaces the file.
+func MigrateConfigFile(path string, passwd []byte) error {
+	data, isEncrypted, err := ReadConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	if IsNative(data) {
+		return errors.New("config is already in the native format")
+	}
+	if !isEncrypted {
+		return errors.New("config is not encrypted")
+	}
+
+	plain, err := decryptConfig(data, passwd)
+	if err != nil {
+		return fmt.Errorf("decrypting config: %v", err)
+	}
+	vault, err := EncryptNative(DefaultScryptParams, passwd, plain)
+	if err != nil {
+		return fmt.Errorf("encrypting config: %v", err)
+	}
+	if err := checkDecrypts(vault, passwd, plain); err != nil {
+		return err
+	}
+	return writeConfig(path, vault)
+}
@@ -0,0 +1,228 @@
+package gauth
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/otp/otpauth"
+)
+
+// The ~/.google_authenticator files of the google-authenticator PAM module
+// hold a base32 secret on their first line, followed by options and
+// emergency scratch codes:
+//
+//	JBSWY3DPEHPK3PXP
+//	" RATE_LIMIT 3 30
+//	" WINDOW_SIZE 17
+//	" DISALLOW_REUSE
+//	" TOTP_AUTH
+//	12345678
+//	87654321
+//
+// HOTP accounts have a HOTP_COUNTER option instead of TOTP_AUTH, and TOTP
+// accounts whose period is not 30 seconds a STEP_SIZE option. Codes always
+// have 6 digits and use SHA1.
+//
+// gauth keeps the options and scratch codes of imported files in the
+// account's comment, after "pam:", so that exporting the account writes
+// them back:
+//
+//	pam: RATE_LIMIT 3 30; WINDOW_SIZE 17; DISALLOW_REUSE; TOTP_AUTH; scratch 12345678 87654321
+
+// DefaultPAMName is the name of accounts imported from PAM files, which do
+// not name them.
+const DefaultPAMName = "pam"
+
+const pamMarker = "pam:"
+
+// pamDefaults are the options of exported accounts without options of their
+// own, those recommended by the google-authenticator tool.
+var pamDefaults = []string{"RATE_LIMIT 3 30", "WINDOW_SIZE 3", "DISALLOW_REUSE"}
+
+// IsPAM reports whether data looks like a google-authenticator PAM file.
+func IsPAM(data []byte) bool {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 2 || !isBase32(strings.TrimSpace(lines[0])) {
+		return false
+	}
+	return strings.HasPrefix(lines[1], `" `) || isScratchCode(strings.TrimSpace(lines[1]))
+}
+
+// ParsePAM returns the account of the google-authenticator PAM file in
+// data, with the given name, and its options and scratch codes in its
+// comment.
+func ParsePAM(data []byte, name string) (Account, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	u := &otpauth.URL{Type: "totp", Account: name, RawSecret: strings.TrimSpace(lines[0])}
+	var options, scratch []string
+	for i, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, `"`):
+			option := strings.Join(strings.Fields(line[1:]), " ")
+			if strings.ContainsAny(option, ";#") {
+				return Account{}, fmt.Errorf("line %d: unsupported option %q", i+2, option)
+			}
+			fields := strings.Fields(option)
+			if len(fields) == 0 {
+				continue
+			}
+			switch fields[0] {
+			case "HOTP_COUNTER":
+				counter, err := strconv.ParseUint(strings.Join(fields[1:], ""), 10, 64)
+				if err != nil {
+					return Account{}, fmt.Errorf("line %d: invalid HOTP_COUNTER", i+2)
+				}
+				u.Type, u.Counter = "hotp", counter
+			case "STEP_SIZE":
+				period, err := strconv.Atoi(strings.Join(fields[1:], ""))
+				if err != nil || period < 1 {
+					return Account{}, fmt.Errorf("line %d: invalid STEP_SIZE", i+2)
+				}
+				u.Period = period
+			}
+			options = append(options, option)
+		case isScratchCode(line):
+			scratch = append(scratch, line)
+		case line == "":
+		default:
+			return Account{}, fmt.Errorf("line %d: neither an option nor a scratch code", i+2)
+		}
+	}
+	if u.Type == "hotp" {
+		u.Period = 0
+	}
+	parsed, err := reparse(u)
+	if err != nil {
+		return Account{}, err
+	}
+	if len(scratch) > 0 {
+		options = append(options, "scratch "+strings.Join(scratch, " "))
+	}
+	return Account{URL: parsed, Comment: pamMarker + " " + strings.Join(options, "; ")}, nil
+}
+
+// CheckPAM reports an error if u cannot be exported to a
+// google-authenticator PAM file, which only knows TOTP and HOTP with SHA1
+// and 6 digits.
+func CheckPAM(u *otpauth.URL) error {
+	if u.Type != "totp" && u.Type != "hotp" {
+		return fmt.Errorf("the PAM module does not support %s accounts", strings.ToUpper(u.Type))
+	}
+	if a := strings.ToUpper(u.Algorithm); a != "" && a != "SHA1" {
+		return fmt.Errorf("the PAM module does not support %s", a)
+	}
+	if u.Digits != 0 && u.Digits != 6 {
+		return fmt.Errorf("the PAM module does not support %d digits", u.Digits)
+	}
+	return nil
+}
+
+// ExportPAM returns a as a google-authenticator PAM file, with the options
+// and scratch codes of its comment, or the tool's recommended options if it
+// has none. The counter of HOTP accounts and the period of TOTP accounts
+// are those of the account.
+func ExportPAM(a Account) ([]byte, error) {
+	u := a.URL
+	if err := CheckPAM(u); err != nil {
+		return nil, err
+	}
+	secret, err := u.Secret()
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %v", err)
+	}
+	var enc otpauth.URL
+	enc.SetSecret(secret)
+	var buf bytes.Buffer
+	buf.WriteString(enc.RawSecret + "\n")
+
+	options, scratch, ok := pamOptions(a.Comment)
+	if !ok {
+		options = append([]string{}, pamDefaults...)
+		if u.Type == "hotp" {
+			options = options[:2] // DISALLOW_REUSE only applies to TOTP
+		}
+	}
+	mode := "TOTP_AUTH"
+	if u.Type == "hotp" {
+		mode = "HOTP_COUNTER " + strconv.FormatUint(u.Counter, 10)
+	}
+	period := u.Period
+	if period == 0 {
+		period = DefaultPeriod
+	}
+	step := ""
+	if u.Type == "totp" && period != DefaultPeriod {
+		step = "STEP_SIZE " + strconv.Itoa(period)
+	}
+
+	var wroteMode, wroteStep bool
+	for _, option := range options {
+		switch strings.Fields(option)[0] {
+		case "TOTP_AUTH", "HOTP_COUNTER":
+			if wroteMode {
+				continue
+			}
+			option, wroteMode = mode, true
+		case "STEP_SIZE":
+			if step == "" || wroteStep {
+				continue
+			}
+			option, wroteStep = step, true
+		}
+		buf.WriteString(`" ` + option + "\n")
+	}
+	if step != "" && !wroteStep {
+		buf.WriteString(`" ` + step + "\n")
+	}
+	if !wroteMode {
+		buf.WriteString(`" ` + mode + "\n")
+	}
+	for _, code := range scratch {
+		buf.WriteString(code + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// pamOptions returns the options and scratch codes that ParsePAM kept in a
+// comment, and whether it has any.
+func pamOptions(comment string) (options, scratch []string, ok bool) {
+	_, note := SplitGroups(comment)
+	i := strings.Index(" "+note, " "+pamMarker)
+	if i < 0 {
+		return nil, nil, false
+	}
+	for _, option := range strings.Split(note[i+len(pamMarker):], ";") {
+		fields := strings.Fields(option)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "scratch":
+			scratch = append(scratch, fields[1:]...)
+		default:
+			options = append(options, strings.Join(fields, " "))
+		}
+	}
+	return options, scratch, true
+}
+
+func isBase32(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'A' && c <= 'Z' || c >= '2' && c <= '7') {
+			return false
+		}
+	}
+	return true
+}
+
+func isScratchCode(s string) bool {
+	if len(s) != 8 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
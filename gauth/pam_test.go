@@ -0,0 +1,113 @@
+package gauth_test
+
+import (
+	"testing"
+
+	"github.com/creachadair/otp/otpauth"
+	"github.com/pcarrier/gauth/gauth"
+)
+
+func TestPAMRoundTrip(t *testing.T) {
+	for _, file := range []string{
+		"JBSWY3DPEHPK3PXP\n\" RATE_LIMIT 3 30 1700000000\n\" WINDOW_SIZE 17\n\" DISALLOW_REUSE 56666666\n\" TOTP_AUTH\n12345678\n87654321\n",
+		"JBSWY3DPEHPK3PXP\n\" RATE_LIMIT 3 30\n\" WINDOW_SIZE 3\n\" STEP_SIZE 60\n\" TOTP_AUTH\n",
+		"JBSWY3DPEHPK3PXP\n\" HOTP_COUNTER 42\n\" WINDOW_SIZE 5\n11223344\n",
+	} {
+		data := []byte(file)
+		if !gauth.IsPAM(data) {
+			t.Fatalf("IsPAM(%q): got false", file)
+		}
+		a, err := gauth.ParsePAM(data, "server")
+		if err != nil {
+			t.Fatalf("ParsePAM(%q): unexpected error: %v", file, err)
+		}
+		if a.URL.Account != "server" || a.URL.RawSecret != "JBSWY3DPEHPK3PXP" {
+			t.Errorf("ParsePAM(%q): got %+v, want account server with secret JBSWY3DPEHPK3PXP", file, a.URL)
+		}
+		got, err := gauth.ExportPAM(a)
+		if err != nil {
+			t.Fatalf("ExportPAM(%q): unexpected error: %v", file, err)
+		}
+		if string(got) != file {
+			t.Errorf("ExportPAM(ParsePAM(%q)): got %q", file, got)
+		}
+	}
+}
+
+func TestParsePAM(t *testing.T) {
+	a, err := gauth.ParsePAM([]byte("JBSWY3DPEHPK3PXP\n\" HOTP_COUNTER 42\n\" WINDOW_SIZE 5\n11223344\n"), "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.URL.Type != "hotp" || a.URL.Counter != 42 {
+		t.Errorf("ParsePAM: got %+v, want HOTP account with counter 42", a.URL)
+	}
+	if want := "pam: HOTP_COUNTER 42; WINDOW_SIZE 5; scratch 11223344"; a.Comment != want {
+		t.Errorf("ParsePAM: got comment %q, want %q", a.Comment, want)
+	}
+
+	a, err = gauth.ParsePAM([]byte("JBSWY3DPEHPK3PXP\n\" STEP_SIZE 60\n\" TOTP_AUTH\n"), "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.URL.Type != "totp" || a.URL.Period != 60 {
+		t.Errorf("ParsePAM: got %+v, want TOTP account with a period of 60", a.URL)
+	}
+
+	for _, file := range []string{
+		"JBSWY3DPEHPK3PXP\n\" HOTP_COUNTER x\n",
+		"JBSWY3DPEHPK3PXP\n\" TOTP_AUTH\nnot a code\n",
+		"JBSWY3DPEHPK3PXP\n\" WINDOW_SIZE 3; TOTP_AUTH\n",
+	} {
+		if _, err := gauth.ParsePAM([]byte(file), "server"); err == nil {
+			t.Errorf("ParsePAM(%q): got nil error", file)
+		}
+	}
+	if gauth.IsPAM([]byte("JBSWY3DPEHPK3PXP\nGEZDGNBVGY3TQOJQ\n")) {
+		t.Error("IsPAM of two secrets: got true")
+	}
+}
+
+func TestExportPAM(t *testing.T) {
+	for _, tc := range []struct {
+		url, comment, want string
+	}{
+		{
+			"otpauth://totp/server?secret=JBSWY3DPEHPK3PXP", "",
+			"JBSWY3DPEHPK3PXP\n\" RATE_LIMIT 3 30\n\" WINDOW_SIZE 3\n\" DISALLOW_REUSE\n\" TOTP_AUTH\n",
+		},
+		{
+			"otpauth://totp/server?secret=JBSWY3DPEHPK3PXP&period=60", "[Servers] bastion",
+			"JBSWY3DPEHPK3PXP\n\" RATE_LIMIT 3 30\n\" WINDOW_SIZE 3\n\" DISALLOW_REUSE\n\" STEP_SIZE 60\n\" TOTP_AUTH\n",
+		},
+		{
+			"otpauth://hotp/server?secret=JBSWY3DPEHPK3PXP&counter=7", "",
+			"JBSWY3DPEHPK3PXP\n\" RATE_LIMIT 3 30\n\" WINDOW_SIZE 3\n\" HOTP_COUNTER 7\n",
+		},
+		{
+			// The counter has moved on since the import.
+			"otpauth://hotp/server?secret=JBSWY3DPEHPK3PXP&counter=9", "[Servers] pam: HOTP_COUNTER 7; WINDOW_SIZE 5; scratch 11223344",
+			"JBSWY3DPEHPK3PXP\n\" HOTP_COUNTER 9\n\" WINDOW_SIZE 5\n11223344\n",
+		},
+	} {
+		u, err := otpauth.ParseURL(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := gauth.ExportPAM(gauth.Account{URL: u, Comment: tc.comment})
+		if err != nil {
+			t.Fatalf("ExportPAM(%s): unexpected error: %v", tc.url, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("ExportPAM(%s, %q): got %q, want %q", tc.url, tc.comment, got, tc.want)
+		}
+	}
+
+	u, err := otpauth.ParseURL("otpauth://totp/server?secret=JBSWY3DPEHPK3PXP&digits=8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gauth.ExportPAM(gauth.Account{URL: u}); err == nil {
+		t.Error("ExportPAM of an 8-digit account: got nil error")
+	}
+}
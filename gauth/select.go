@@ -0,0 +1,132 @@
+package gauth
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/otp/otpauth"
+)
+
+// Select returns the accounts of urls picked by selector, which is one of:
+//
+//   - a name, such as "alice", "GitHub:alice" or "GitHub/alice", picking the
+//     accounts with that name, alone or prefixed by their issuer, ignoring
+//     case;
+//   - "issuer:" followed by an issuer, or a glob pattern of issuers, picking
+//     the accounts of those issuers, ignoring case;
+//   - "tag:" followed by a tag, or a glob pattern of tags, picking the
+//     accounts with those tags, ignoring case, as returned by tags, which may
+//     be nil if accounts have no tags;
+//   - a glob pattern, with '*', '?' or '[', matched as by path.Match against
+//     the same names, ignoring case;
+//   - a regular expression between slashes, such as "/^git/", picking the
+//     accounts whose names contain a match.
+//
+// If partial is set and selector is a name picking no account, the accounts
+// whose name contains it, ignoring case, are returned instead.
+func Select(urls []*otpauth.URL, tags func(*otpauth.URL) []string, selector string, partial bool) ([]*otpauth.URL, error) {
+	match, err := compileSelector(selector, tags)
+	if err != nil {
+		return nil, err
+	}
+	var selected []*otpauth.URL
+	for _, u := range urls {
+		if match(u) {
+			selected = append(selected, u)
+		}
+	}
+	if len(selected) == 0 && partial && isNameSelector(selector) {
+		for _, u := range urls {
+			if strings.Contains(strings.ToLower(u.Account), strings.ToLower(selector)) {
+				selected = append(selected, u)
+			}
+		}
+	}
+	return selected, nil
+}
+
+// isNameSelector reports whether selector is a plain name rather than an
+// issuer, a tag, a glob pattern or a regular expression.
+func isNameSelector(selector string) bool {
+	_, isIssuer := prefixSelector(selector, issuerPrefix)
+	_, isTag := prefixSelector(selector, tagPrefix)
+	return !isIssuer && !isTag && !isRegexpSelector(selector) && !strings.ContainsAny(selector, "*?[")
+}
+
+// compileSelector returns a function reporting whether selector picks an
+// account.
+func compileSelector(selector string, tags func(*otpauth.URL) []string) (func(*otpauth.URL) bool, error) {
+	// matchNames reports whether match accepts a name of u.
+	matchNames := func(match func(string) bool) func(*otpauth.URL) bool {
+		return func(u *otpauth.URL) bool {
+			return match(u.Account) ||
+				u.Issuer != "" && (match(u.Issuer+":"+u.Account) || match(u.Issuer+"/"+u.Account))
+		}
+	}
+	if selector == "" {
+		return nil, errors.New("empty selector")
+	}
+	if isRegexpSelector(selector) {
+		re, err := regexp.Compile(selector[1 : len(selector)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		return matchNames(re.MatchString), nil
+	}
+	if issuer, ok := prefixSelector(selector, issuerPrefix); ok {
+		match, err := compileGlob(issuer)
+		if err != nil {
+			return nil, err
+		}
+		return func(u *otpauth.URL) bool { return u.Issuer != "" && match(u.Issuer) }, nil
+	}
+	if tag, ok := prefixSelector(selector, tagPrefix); ok {
+		match, err := compileGlob(tag)
+		if err != nil {
+			return nil, err
+		}
+		return func(u *otpauth.URL) bool {
+			return tags != nil && slices.ContainsFunc(tags(u), match)
+		}, nil
+	}
+	match, err := compileGlob(selector)
+	if err != nil {
+		return nil, err
+	}
+	return matchNames(match), nil
+}
+
+// compileGlob returns a function reporting whether a name matches pattern,
+// a glob pattern or a plain name, ignoring case.
+func compileGlob(pattern string) (func(string) bool, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return func(name string) bool { return strings.EqualFold(name, pattern) }, nil
+	}
+	lower := strings.ToLower(pattern)
+	if _, err := path.Match(lower, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q", pattern)
+	}
+	return func(name string) bool {
+		ok, _ := path.Match(lower, strings.ToLower(name))
+		return ok
+	}, nil
+}
+
+func isRegexpSelector(selector string) bool {
+	return len(selector) > 2 && strings.HasPrefix(selector, "/") && strings.HasSuffix(selector, "/")
+}
+
+const issuerPrefix, tagPrefix = "issuer:", "tag:"
+
+// prefixSelector returns what follows prefix in selector, if selector
+// starts with it, ignoring case.
+func prefixSelector(selector, prefix string) (string, bool) {
+	if len(selector) > len(prefix) && strings.EqualFold(selector[:len(prefix)], prefix) {
+		return selector[len(prefix):], true
+	}
+	return "", false
+}
@@ -0,0 +1,71 @@
+package gauth_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pcarrier/gauth/gauth"
+)
+
+func TestSelect(t *testing.T) {
+	v, err := gauth.ParseVault([]byte(strings.Join([]string{
+		"otpauth://totp/GitHub:alice?secret=JBSWY3DPEHPK3PXP # [Prod, Work] main",
+		"otpauth://totp/GitHub:bob?secret=JBSWY3DPEHPK3PXP # [staging]",
+		"otpauth://totp/GitLab:alice?secret=JBSWY3DPEHPK3PXP",
+		"github:JBSWY3DPEHPK3PXP # not [a tag]",
+		"vpn:JBSWY3DPEHPK3PXP # [prod]",
+		"",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := v.URLs()
+
+	for _, tc := range []struct {
+		selector string
+		partial  bool
+		want     string
+	}{
+		{"alice", false, "GitHub:alice GitLab:alice"},
+		{"GitHub:Alice", false, "GitHub:alice"},
+		{"github", false, ":github"},
+		{"git", false, ""},
+		{"git", true, ":github"},
+		{"ali", true, "GitHub:alice GitLab:alice"},
+		{"issuer:github", false, "GitHub:alice GitHub:bob"},
+		{"issuer:github", true, "GitHub:alice GitHub:bob"},
+		{"issuer:git*", false, "GitHub:alice GitHub:bob GitLab:alice"},
+		{"gitlab/ALICE", false, "GitLab:alice"},
+		{"git*", false, "GitHub:alice GitHub:bob GitLab:alice :github"},
+		{"*:alice", false, "GitHub:alice GitLab:alice"},
+		{"v?n", false, ":vpn"},
+		{"/^Git(Hub|Lab):a/", false, "GitHub:alice GitLab:alice"},
+		{"/b$/", true, "GitHub:bob :github"},
+		{"/x/", true, ""},
+		{"tag:prod", false, "GitHub:alice :vpn"},
+		{"tag:*ing", true, "GitHub:bob"},
+		{"tag:a tag", true, ""},
+	} {
+		selected, err := gauth.Select(urls, v.Tags, tc.selector, tc.partial)
+		if err != nil {
+			t.Errorf("Select(%q, %v): unexpected error: %v", tc.selector, tc.partial, err)
+			continue
+		}
+		var names []string
+		for _, u := range selected {
+			names = append(names, u.Issuer+":"+u.Account)
+		}
+		if got := strings.Join(names, " "); got != tc.want {
+			t.Errorf("Select(%q, %v): got %q, want %q", tc.selector, tc.partial, got, tc.want)
+		}
+	}
+
+	if selected, err := gauth.Select(urls, nil, "tag:prod", false); err != nil || len(selected) != 0 {
+		t.Errorf("Select(tag:prod) without tags: got %d accounts, %v; want none", len(selected), err)
+	}
+	for _, selector := range []string{"", "/(/", "[a-", "tag:[a-"} {
+		if _, err := gauth.Select(urls, nil, selector, true); err == nil {
+			t.Errorf("Select(%q): got nil error", selector)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package gauth
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/creachadair/otp/otpauth"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// 2FAS backups (.2fas files) are JSON documents listing services and
+// groups. Encrypted backups have no services; servicesEncrypted holds the
+// JSON list of services instead, as "ciphertext:salt:iv" in base64, where
+// the ciphertext is AES-256-GCM with its tag appended, and the key comes
+// from PBKDF2-SHA256 with 10000 iterations over the password.
+
+const twoFASIterations = 10000
+
+const twoFASSchemaVersion = 4
+
+type twoFASBackup struct {
+	Services          []twoFASService `json:"services"`
+	ServicesEncrypted string          `json:"servicesEncrypted,omitempty"`
+	Groups            []twoFASGroup   `json:"groups"`
+	UpdatedAt         int64           `json:"updatedAt,omitempty"`
+	SchemaVersion     int             `json:"schemaVersion"`
+}
+
+type twoFASGroup struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	IsExpanded bool   `json:"isExpanded"`
+	UpdatedAt  int64  `json:"updatedAt,omitempty"`
+}
+
+type twoFASService struct {
+	Name      string `json:"name"`
+	Secret    string `json:"secret"`
+	UpdatedAt int64  `json:"updatedAt,omitempty"`
+	GroupID   string `json:"groupId,omitempty"`
+	OTP       struct {
+		Account   string `json:"account"`
+		Issuer    string `json:"issuer,omitempty"`
+		Digits    int    `json:"digits"`
+		Period    int    `json:"period"`
+		Algorithm string `json:"algorithm"`
+		Counter   uint64 `json:"counter"`
+		TokenType string `json:"tokenType"`
+		Source    string `json:"source,omitempty"`
+	} `json:"otp"`
+	Order struct {
+		Position int `json:"position"`
+	} `json:"order"`
+}
+
+// Is2FAS reports whether data looks like a 2FAS backup.
+func Is2FAS(data []byte) bool {
+	var v struct {
+		Services      *json.RawMessage `json:"services"`
+		SchemaVersion *int             `json:"schemaVersion"`
+	}
+	return json.Unmarshal(data, &v) == nil && v.Services != nil && v.SchemaVersion != nil
+}
+
+// Parse2FAS returns the accounts of the 2FAS backup in data, decrypting it
+// with the password returned by getPass if it is encrypted. Accounts are
+// named after the service's account, with the service's name as issuer, and
+// commented with the service's group in brackets.
+func Parse2FAS(data []byte, getPass func() ([]byte, error)) ([]Account, []ImportFailure, error) {
+	var backup twoFASBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, nil, fmt.Errorf("invalid 2FAS backup: %v", err)
+	}
+	services := backup.Services
+	if backup.ServicesEncrypted != "" {
+		passwd, err := getPass()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading passphrase: %v", err)
+		}
+		plain, err := decrypt2FAS(backup.ServicesEncrypted, passwd)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(plain, &services); err != nil {
+			return nil, nil, fmt.Errorf("invalid 2FAS backup: %v", err)
+		}
+	}
+
+	groups := make(map[string]string)
+	for _, g := range backup.Groups {
+		groups[g.ID] = g.Name
+	}
+	var accounts []Account
+	var failed []ImportFailure
+	for _, s := range services {
+		u := &otpauth.URL{
+			Type:      strings.ToLower(s.OTP.TokenType),
+			Issuer:    s.Name,
+			Account:   s.OTP.Account,
+			RawSecret: s.Secret,
+			Algorithm: strings.ToUpper(s.OTP.Algorithm),
+			Digits:    s.OTP.Digits,
+			Period:    s.OTP.Period,
+		}
+		if u.Account == "" {
+			u.Issuer, u.Account = "", s.Name
+		}
+		switch u.Type {
+		case "totp":
+		case "hotp":
+			u.Period = 0
+			u.Counter = s.OTP.Counter
+		case "steam":
+			u.Algorithm, u.Digits = "", 0
+		default:
+			failed = append(failed, ImportFailure{URL: u, Err: fmt.Errorf("unsupported 2FAS token type %q", s.OTP.TokenType)})
+			continue
+		}
+		parsed, err := reparse(u)
+		if err != nil {
+			failed = append(failed, ImportFailure{URL: u, Err: err})
+			continue
+		}
+
+		var names []string
+		if name, ok := groups[s.GroupID]; ok {
+			names = append(names, name)
+		}
+		accounts = append(accounts, Account{URL: parsed, Comment: JoinGroups(names, "")})
+	}
+	return accounts, failed, nil
+}
+
+// decrypt2FAS decrypts the servicesEncrypted field of a 2FAS backup.
+func decrypt2FAS(encrypted string, passwd []byte) ([]byte, error) {
+	parts := strings.Split(encrypted, ":")
+	if len(parts) != 3 {
+		return nil, errors.New("invalid encrypted 2FAS backup")
+	}
+	var decoded [3][]byte
+	for i, p := range parts {
+		var err error
+		if decoded[i], err = base64.StdEncoding.DecodeString(p); err != nil {
+			return nil, fmt.Errorf("invalid encrypted 2FAS backup: %v", err)
+		}
+	}
+	ciphertext, salt, iv := decoded[0], decoded[1], decoded[2]
+	key := pbkdf2.Key(passwd, salt, twoFASIterations, 32, sha256.New)
+	plain, err := openGCM(key, iv, ciphertext)
+	if err != nil {
+		return nil, errors.New("invalid password for the 2FAS backup")
+	}
+	return plain, nil
+}
+
+// Export2FAS returns accounts as an unencrypted 2FAS backup. Services are
+// named after the issuer of accounts, or the account itself without one,
+// and put in the first group of their comment, as 2FAS services have a
+// single group.
+func Export2FAS(accounts []Account) ([]byte, error) {
+	now := time.Now().UnixMilli()
+	backup := twoFASBackup{Services: []twoFASService{}, Groups: []twoFASGroup{}, UpdatedAt: now, SchemaVersion: twoFASSchemaVersion}
+	groupIDs := make(map[string]string)
+	for i, a := range accounts {
+		u := a.URL
+		secret, err := u.Secret()
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid secret: %v", u.Account, err)
+		}
+		s := twoFASService{Name: cmp.Or(u.Issuer, u.Account), UpdatedAt: now}
+		s.Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+		if u.Issuer != "" {
+			// Services without an issuer are named after the account alone.
+			s.OTP.Account, s.OTP.Issuer = u.Account, u.Issuer
+		}
+		s.OTP.TokenType, s.OTP.Source = strings.ToUpper(u.Type), "Manual"
+		s.OTP.Algorithm = cmp.Or(strings.ToUpper(u.Algorithm), "SHA1")
+		s.OTP.Digits = cmp.Or(u.Digits, 6)
+		s.OTP.Period = cmp.Or(u.Period, DefaultPeriod)
+		s.Order.Position = i
+		switch u.Type {
+		case "totp":
+		case "hotp":
+			s.OTP.Counter = u.Counter
+		case "steam":
+			s.OTP.Algorithm, s.OTP.Digits = "SHA1", steamDigits
+		default:
+			return nil, fmt.Errorf("%s: unsupported type: %q", u.Account, u.Type)
+		}
+
+		if groups, _ := SplitGroups(a.Comment); len(groups) > 0 {
+			id, ok := groupIDs[groups[0]]
+			if !ok {
+				id = newUUID()
+				groupIDs[groups[0]] = id
+				backup.Groups = append(backup.Groups, twoFASGroup{ID: id, Name: groups[0], IsExpanded: true, UpdatedAt: now})
+			}
+			s.GroupID = id
+		}
+		backup.Services = append(backup.Services, s)
+	}
+	return json.MarshalIndent(backup, "", "  ")
+}
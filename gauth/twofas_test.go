@@ -0,0 +1,89 @@
+package gauth_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pcarrier/gauth/gauth"
+)
+
+// testdata/2fas-encrypted.2fas holds the services of testdata/2fas.2fas,
+// encrypted with the password "hunter2".
+
+func TestParse2FAS(t *testing.T) {
+	for _, file := range []string{"testdata/2fas.2fas", "testdata/2fas-encrypted.2fas"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !gauth.Is2FAS(data) {
+			t.Fatalf("Is2FAS(%s): got false", file)
+		}
+		accounts, failed, err := gauth.Parse2FAS(data, func() ([]byte, error) { return []byte("hunter2"), nil })
+		if err != nil {
+			t.Fatalf("Parse2FAS(%s): unexpected error: %v", file, err)
+		}
+		if len(accounts) != 4 || len(failed) != 0 {
+			t.Fatalf("Parse2FAS(%s): got %d accounts and %d failures, want 4 and 0", file, len(accounts), len(failed))
+		}
+
+		github := accounts[0]
+		if github.URL.Issuer != "GitHub" || github.URL.Account != "alice" || github.Comment != "[Work]" {
+			t.Errorf("Parse2FAS(%s): got %+v, %q; want GitHub:alice commented [Work]", file, github.URL, github.Comment)
+		}
+		bank := accounts[1].URL
+		if bank.Type != "totp" || bank.Algorithm != "SHA256" || bank.Digits != 8 || bank.Period != 60 {
+			t.Errorf("Parse2FAS(%s): got %+v, want TOTP account with SHA256, 8 digits and a period of 60", file, bank)
+		}
+		vpn := accounts[2].URL
+		if vpn.Type != "hotp" || vpn.Algorithm != "SHA512" || vpn.Digits != 8 || vpn.Counter != 5 {
+			t.Errorf("Parse2FAS(%s): got %+v, want HOTP account with SHA512, 8 digits and counter 5", file, vpn)
+		}
+		if steam := accounts[3].URL; steam.Type != "steam" || steam.Account != "Steam" {
+			t.Errorf("Parse2FAS(%s): got %+v, want Steam account named Steam", file, steam)
+		}
+	}
+
+	data, err := os.ReadFile("testdata/2fas-encrypted.2fas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := gauth.Parse2FAS(data, func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+		t.Error("Parse2FAS with a wrong password: got nil error")
+	}
+}
+
+func TestExport2FAS(t *testing.T) {
+	data, err := os.ReadFile("testdata/2fas.2fas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, _, err := gauth.Parse2FAS(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the first of several tags becomes the service's group.
+	accounts[1].Comment = "[Bank, Personal] savings"
+
+	exported, err := gauth.Export2FAS(accounts)
+	if err != nil {
+		t.Fatalf("Export2FAS: unexpected error: %v", err)
+	}
+	if !gauth.Is2FAS(exported) {
+		t.Fatal("Is2FAS(Export2FAS()): got false")
+	}
+	got, failed, err := gauth.Parse2FAS(exported, nil)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Parse2FAS(Export2FAS()): %v, %v", failed, err)
+	}
+	if len(got) != len(accounts) {
+		t.Fatalf("round trip: got %d accounts, want %d", len(got), len(accounts))
+	}
+	wantComments := []string{"[Work]", "[Bank]", accounts[2].Comment, accounts[3].Comment}
+	for i, a := range accounts {
+		g := got[i]
+		if *g.URL != *a.URL || g.Comment != wantComments[i] {
+			t.Errorf("round trip: got %+v %q, want %+v %q", g.URL, g.Comment, a.URL, wantComments[i])
+		}
+	}
+}
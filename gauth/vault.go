@@ -0,0 +1,394 @@
+package gauth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/creachadair/otp/otpauth"
+)
+
+// A Vault is a config file that can be edited without disturbing it: lines
+// keep their order and formatting, blank lines and comments are kept, and
+// only the lines of edited accounts are rewritten, along with their trailing
+// comments. The URLs returned by a Vault belong to it,
+// and identify accounts for Update, Remove and Rename.
+type Vault struct {
+	// Path is the file the vault was loaded from, and is saved to.
+	Path string
+
+	password []byte
+	lines    []vaultLine
+	saved    []byte // the plaintext of Path when loaded or last saved
+}
+
+// vaultLine is a line of a vault, without its trailing newline.
+type vaultLine struct {
+	text    string
+	url     *otpauth.URL // nil for lines that do not describe an account
+	comment string       // trailing comment of text, with the space before it
+}
+
+// LoadVault reads the config at path, decrypting it if necessary, and parses
+// it as a Vault. The getPass function is called to obtain a password if
+// needed. If path does not exist, LoadVault returns an empty Vault, which
+// Save creates as a plaintext config.
+func LoadVault(path string, getPass func() ([]byte, error)) (*Vault, error) {
+	data, isEncrypted, err := ReadConfigFile(path)
+	if os.IsNotExist(err) {
+		return &Vault{Path: path}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading config file: %v", err)
+	}
+
+	var passwd []byte
+	if isEncrypted {
+		if passwd, err = getPass(); err != nil {
+			return nil, fmt.Errorf("reading passphrase: %v", err)
+		}
+		if data, err = decryptConfig(data, passwd); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := ParseVault(data)
+	if err != nil {
+		return nil, err
+	}
+	v.Path = path
+	v.password = passwd
+	v.saved = data
+	return v, nil
+}
+
+// NewVault returns an empty Vault to be saved at path. Save creates it as a
+// native vault protected by passwd, or as a plaintext config if passwd is
+// nil.
+func NewVault(path string, passwd []byte) *Vault {
+	return &Vault{Path: path, password: passwd}
+}
+
+// Encrypted reports whether v is saved encrypted.
+func (v *Vault) Encrypted() bool {
+	return v.password != nil
+}
+
+// ParseVault parses the contents of data as a gauth configuration file. The
+// result has no Path; set one before calling Save.
+func ParseVault(data []byte) (*Vault, error) {
+	v := new(Vault)
+	if len(data) == 0 {
+		return v, nil
+	}
+	for i, text := range strings.Split(string(data), "\n") {
+		content, comment := splitComment(text)
+		line := vaultLine{text: text, comment: comment}
+		if trim := strings.TrimSpace(content); trim != "" {
+			u, err := parseConfigLine(trim, i+1)
+			if err != nil {
+				return nil, err
+			}
+			line.url = u
+		}
+		v.lines = append(v.lines, line)
+	}
+	return v, nil
+}
+
+// Bytes returns the plaintext contents of v.
+func (v *Vault) Bytes() []byte {
+	texts := make([]string, len(v.lines))
+	for i, line := range v.lines {
+		texts[i] = line.text
+	}
+	return []byte(strings.Join(texts, "\n"))
+}
+
+// Save writes v to v.Path with WriteConfigFile, encrypted with the password
+// it was loaded with if it was encrypted. Saves that only advance HOTP
+// counters do not back up the file, so that using HOTP codes does not push
+// the backups of real edits out.
+func (v *Vault) Save() error {
+	if v.Path == "" {
+		return errors.New("vault has no path")
+	}
+	data := v.Bytes()
+	backup := v.saved == nil || !onlyCountersDiffer(v.saved, data)
+	if err := writeConfigFile(v.Path, v.password, data, backup); err != nil {
+		return err
+	}
+	v.saved = data
+	return nil
+}
+
+// onlyCountersDiffer reports whether the configs old and new are the same
+// but for the counters of HOTP accounts.
+func onlyCountersDiffer(old, new []byte) bool {
+	oldLines, newLines := strings.Split(string(old), "\n"), strings.Split(string(new), "\n")
+	if len(oldLines) != len(newLines) {
+		return false
+	}
+	for i, o := range oldLines {
+		n := newLines[i]
+		if o == n {
+			continue
+		}
+		oldContent, oldComment := splitComment(o)
+		newContent, newComment := splitComment(n)
+		if oldComment != newComment || strings.TrimSpace(oldContent) == "" || strings.TrimSpace(newContent) == "" {
+			return false
+		}
+		oldURL, oldErr := parseConfigLine(strings.TrimSpace(oldContent), i+1)
+		newURL, newErr := parseConfigLine(strings.TrimSpace(newContent), i+1)
+		if oldErr != nil || newErr != nil || oldURL.Type != "hotp" || newURL.Type != "hotp" {
+			return false
+		}
+		oldURL.Counter = newURL.Counter
+		if *oldURL != *newURL {
+			return false
+		}
+	}
+	return true
+}
+
+// URLs returns the accounts of v, in the order of the file.
+func (v *Vault) URLs() []*otpauth.URL {
+	var out []*otpauth.URL
+	for _, line := range v.lines {
+		if line.url != nil {
+			out = append(out, line.url)
+		}
+	}
+	return out
+}
+
+// Find returns the first account of v named account, or nil.
+func (v *Vault) Find(account string) *otpauth.URL {
+	for _, u := range v.URLs() {
+		if u.Account == account {
+			return u
+		}
+	}
+	return nil
+}
+
+// Add appends u to v, in the name:secret form when it can represent u. It
+// reports an error if v already has an account with the same name and issuer,
+// or if no code can be generated from u.
+func (v *Vault) Add(u *otpauth.URL) error {
+	if err := checkURL(u); err != nil {
+		return err
+	}
+	if v.exists(u.Issuer, u.Account, nil) {
+		return fmt.Errorf("account %q already exists", u.Account)
+	}
+
+	line := vaultLine{text: formatLine(u, true), url: u}
+	if n := len(v.lines); n > 0 && v.lines[n-1].text == "" {
+		// Keep the trailing newline last.
+		v.lines = append(v.lines[:n-1], line, v.lines[n-1])
+	} else {
+		v.lines = append(v.lines, line, vaultLine{})
+	}
+	return nil
+}
+
+// Update rewrites the line of u, which must belong to v, after u has been
+// modified. Lines in the name:secret form keep it when possible.
+func (v *Vault) Update(u *otpauth.URL) error {
+	i := v.index(u)
+	if i < 0 {
+		return fmt.Errorf("account %q is not in the vault", u.Account)
+	}
+	if err := checkURL(u); err != nil {
+		return err
+	}
+	line := &v.lines[i]
+	legacy := !strings.HasPrefix(strings.TrimSpace(line.text), "otpauth://")
+	line.text = formatLine(u, legacy) + lineEnd(line)
+	return nil
+}
+
+// lineEnd returns what follows the account in line: its trailing comment,
+// or the carriage return of a CRLF line ending.
+func lineEnd(line *vaultLine) string {
+	if line.comment == "" && strings.HasSuffix(line.text, "\r") {
+		return "\r"
+	}
+	return line.comment
+}
+
+// Comment returns the trailing comment of u, which must belong to v, without
+// its leading '#'.
+func (v *Vault) Comment(u *otpauth.URL) string {
+	i := v.index(u)
+	if i < 0 {
+		return ""
+	}
+	c := strings.TrimSpace(v.lines[i].comment)
+	return strings.TrimSpace(strings.TrimPrefix(c, "#"))
+}
+
+// SetComment replaces the trailing comment of u, which must belong to v.
+// An empty comment removes it.
+func (v *Vault) SetComment(u *otpauth.URL, comment string) error {
+	i := v.index(u)
+	if i < 0 {
+		return fmt.Errorf("account %q is not in the vault", u.Account)
+	}
+	if strings.ContainsAny(comment, "\r\n") {
+		return errors.New("comments cannot span several lines")
+	}
+	line := &v.lines[i]
+	cr := strings.HasSuffix(line.text, "\r")
+	content := strings.TrimSuffix(strings.TrimSuffix(line.text, line.comment), "\r")
+	line.comment = ""
+	if comment = strings.TrimSpace(comment); comment != "" {
+		line.comment = " # " + comment
+	}
+	if cr {
+		line.comment += "\r"
+	}
+	line.text = content + line.comment
+	return nil
+}
+
+// Tags returns the tags of u, the group names in brackets at the start of
+// its comment, as JoinGroups writes them. Accounts that do not belong to v
+// have no tags.
+func (v *Vault) Tags(u *otpauth.URL) []string {
+	tags, _ := SplitGroups(v.Comment(u))
+	return tags
+}
+
+// SetTags replaces the tags of u, which must belong to v, keeping the rest
+// of its comment.
+func (v *Vault) SetTags(u *otpauth.URL, tags []string) error {
+	for _, tag := range tags {
+		if tag == "" || tag != strings.TrimSpace(tag) || strings.ContainsAny(tag, "[],#\r\n") {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+	_, note := SplitGroups(v.Comment(u))
+	return v.SetComment(u, JoinGroups(tags, note))
+}
+
+// Remove deletes u, which must belong to v.
+func (v *Vault) Remove(u *otpauth.URL) error {
+	i := v.index(u)
+	if i < 0 {
+		return fmt.Errorf("account %q is not in the vault", u.Account)
+	}
+	v.lines = append(v.lines[:i], v.lines[i+1:]...)
+	return nil
+}
+
+// Rename changes the name of u, which must belong to v, to account.
+func (v *Vault) Rename(u *otpauth.URL, account string) error {
+	if v.index(u) < 0 {
+		return fmt.Errorf("account %q is not in the vault", u.Account)
+	}
+	if strings.TrimSpace(account) == "" {
+		return errors.New("empty account name")
+	}
+	if v.exists(u.Issuer, account, u) {
+		return fmt.Errorf("account %q already exists", account)
+	}
+	old := u.Account
+	u.Account = account
+	if err := v.Update(u); err != nil {
+		u.Account = old
+		return err
+	}
+	return nil
+}
+
+func (v *Vault) index(u *otpauth.URL) int {
+	for i, line := range v.lines {
+		if line.url == u {
+			return i
+		}
+	}
+	return -1
+}
+
+// exists reports whether v has an account other than except with the given
+// issuer and name.
+func (v *Vault) exists(issuer, account string, except *otpauth.URL) bool {
+	for _, u := range v.URLs() {
+		if u != except && u.Issuer == issuer && u.Account == account {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL reports an error if no code can be generated from u.
+func checkURL(u *otpauth.URL) error {
+	switch u.Type {
+	case "totp", "hotp", "steam":
+	default:
+		return fmt.Errorf("unsupported type: %q", u.Type)
+	}
+	if strings.TrimSpace(u.Account) == "" {
+		return errors.New("empty account name")
+	}
+	_, err := newConfig(u)
+	return err
+}
+
+// splitComment splits line into its content and its trailing comment. Lines
+// starting with '#' are comments; otherwise a comment starts at a '#' that
+// follows whitespace after the first ':', so that account names may contain
+// '#'. The comment includes the whitespace before it.
+func splitComment(line string) (content, comment string) {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return "", line
+	}
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		return line, ""
+	}
+	for i := colon + 1; i < len(line); i++ {
+		if line[i] != '#' || (line[i-1] != ' ' && line[i-1] != '\t') {
+			continue
+		}
+		j := i
+		for j > colon+1 && (line[j-1] == ' ' || line[j-1] == '\t') {
+			j--
+		}
+		return line[:j], line[j:]
+	}
+	return line, ""
+}
+
+// formatLine renders u as a config line, in the name:secret form if legacy
+// is set and that form can represent u.
+func formatLine(u *otpauth.URL, legacy bool) string {
+	if legacy && isLegacy(u) {
+		return FormatLegacyLine(u)
+	}
+	return u.String()
+}
+
+// isLegacy reports whether the name:secret form can represent u, naming it
+// issuer/account if it has an issuer.
+func isLegacy(u *otpauth.URL) bool {
+	if u.Issuer != "" {
+		if u.Issuer != strings.TrimSpace(u.Issuer) || strings.ContainsAny(u.Issuer, "/:\n") ||
+			strings.TrimSpace(u.Account) == "" {
+			return false
+		}
+	} else if strings.Contains(u.Account, "/") {
+		return false
+	}
+	return (u.Type == "totp" || u.Type == "hotp") &&
+		(u.Algorithm == "" || u.Algorithm == "SHA1") &&
+		(u.Digits == 0 || u.Digits == 6) &&
+		(u.Period == 0 || u.Period == DefaultPeriod) &&
+		u.Account == strings.TrimSpace(u.Account) &&
+		!strings.ContainsAny(u.Account, ":\n") &&
+		!strings.HasPrefix(u.Account, "otpauth://") &&
+		!strings.ContainsAny(u.RawSecret, ":\n")
+}
@@ -0,0 +1,287 @@
+package gauth_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/creachadair/otp/otpauth"
+	"github.com/pcarrier/gauth/gauth"
+)
+
+const vaultConfig = "vpn:GEZDGNBVGY3TQOJQ:0\r\n" +
+	"\n" +
+	"  AWS:   ABCD EFGH\n" +
+	"otpauth://totp/Corp:admin?secret=GEZDGNBVGY3TQOJQ&digits=8\n"
+
+func TestVaultEdits(t *testing.T) {
+	v, err := gauth.ParseVault([]byte(vaultConfig))
+	if err != nil {
+		t.Fatalf("ParseVault: unexpected error: %v", err)
+	}
+	if got := string(v.Bytes()); got != vaultConfig {
+		t.Errorf("Bytes of an unmodified vault: got %q, want %q", got, vaultConfig)
+	}
+	if n := len(v.URLs()); n != 3 {
+		t.Fatalf("URLs: got %d accounts, want 3", n)
+	}
+
+	vpn := v.Find("vpn")
+	if vpn == nil {
+		t.Fatal(`Find("vpn"): got nil`)
+	}
+	if v.Find("vp") != nil {
+		t.Error(`Find("vp"): got a partial match`)
+	}
+	vpn.Counter = 7
+	if err := v.Update(vpn); err != nil {
+		t.Errorf("Update: unexpected error: %v", err)
+	}
+	if err := v.Rename(v.Find("admin"), "root"); err != nil {
+		t.Errorf("Rename: unexpected error: %v", err)
+	}
+	if err := v.Rename(v.Find("AWS"), "vpn"); err == nil {
+		t.Error("Rename to an existing name: got nil error")
+	}
+	if err := v.Remove(v.Find("AWS")); err != nil {
+		t.Errorf("Remove: unexpected error: %v", err)
+	}
+	if err := v.Remove(&otpauth.URL{Account: "AWS"}); err == nil {
+		t.Error("Remove of a URL from elsewhere: got nil error")
+	}
+	if err := v.Add(&otpauth.URL{Type: "totp", Account: "new", RawSecret: "ABCDEFGH"}); err != nil {
+		t.Errorf("Add: unexpected error: %v", err)
+	}
+	if err := v.Add(&otpauth.URL{Type: "totp", Issuer: "Web", Account: "me", RawSecret: "ABCDEFGH", Digits: 8}); err != nil {
+		t.Errorf("Add: unexpected error: %v", err)
+	}
+	if err := v.Add(&otpauth.URL{Type: "totp", Issuer: "Web", Account: "admin", RawSecret: "ABCDEFGH"}); err != nil {
+		t.Errorf("Add: unexpected error: %v", err)
+	}
+	if err := v.Add(&otpauth.URL{Type: "totp", Account: "vpn", RawSecret: "ABCDEFGH"}); err == nil {
+		t.Error("Add of an existing account: got nil error")
+	}
+	if err := v.Add(&otpauth.URL{Type: "totp", Account: "bad", RawSecret: "blargh!"}); err == nil {
+		t.Error("Add with an invalid secret: got nil error")
+	}
+
+	want := "vpn:GEZDGNBVGY3TQOJQ:7\r\n" +
+		"\n" +
+		"otpauth://totp/Corp:root?digits=8&issuer=Corp&secret=GEZDGNBVGY3TQOJQ\n" +
+		"new:ABCDEFGH\n" +
+		"otpauth://totp/Web:me?digits=8&issuer=Web&secret=ABCDEFGH\n" +
+		"Web/admin:ABCDEFGH\n"
+	if got := string(v.Bytes()); got != want {
+		t.Errorf("Bytes of the edited vault:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestVaultSave(t *testing.T) {
+	getPass := func() ([]byte, error) { return []byte("x"), nil }
+	orig, err := os.ReadFile("testdata/encrypted.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "gauth.csv")
+	if err := os.WriteFile(path, orig, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := gauth.LoadVault(path, getPass)
+	if err != nil {
+		t.Fatalf("LoadVault: unexpected error: %v", err)
+	}
+	if err := v.Remove(v.Find("test1")); err != nil {
+		t.Fatalf("Remove: unexpected error: %v", err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatalf("Save: unexpected error: %v", err)
+	}
+
+	if _, isEncrypted, err := gauth.ReadConfigFile(path); err != nil || !isEncrypted {
+		t.Errorf("Saved vault: encrypted is %v, %v, want true", isEncrypted, err)
+	}
+	v, err = gauth.LoadVault(path, getPass)
+	if err != nil {
+		t.Fatalf("LoadVault of the saved vault: unexpected error: %v", err)
+	}
+	if n := len(v.URLs()); n != 2 || v.Find("test1") != nil {
+		t.Errorf("Saved vault: got %d accounts, want 2 without test1", n)
+	}
+
+	// Missing files load as empty vaults.
+	v, err = gauth.LoadVault(filepath.Join(t.TempDir(), "missing.csv"), getPass)
+	if err != nil {
+		t.Fatalf("LoadVault of a missing file: unexpected error: %v", err)
+	} else if len(v.URLs()) != 0 {
+		t.Errorf("LoadVault of a missing file: got %d accounts, want 0", len(v.URLs()))
+	}
+}
+
+func TestNewVault(t *testing.T) {
+	dir := t.TempDir()
+	for _, passwd := range [][]byte{nil, []byte("x")} {
+		path := filepath.Join(dir, fmt.Sprintf("new-%t.csv", passwd != nil))
+		v := gauth.NewVault(path, passwd)
+		if v.Encrypted() != (passwd != nil) {
+			t.Errorf("NewVault(%q): Encrypted is %v", passwd, v.Encrypted())
+		}
+		u, err := otpauth.ParseURL("otpauth://totp/web?secret=JBSWY3DPEHPK3PXP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Add(u); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Save(); err != nil {
+			t.Fatalf("Save: unexpected error: %v", err)
+		}
+		data, isEncrypted, err := gauth.ReadConfigFile(path)
+		if err != nil || isEncrypted != (passwd != nil) {
+			t.Errorf("Saved vault: encrypted is %v, %v, want %v", isEncrypted, err, passwd != nil)
+		}
+		if passwd != nil && (!gauth.IsNative(data) || bytes.Contains(data, []byte("JBSWY3DPEHPK3PXP"))) {
+			t.Error("Saved vault: want a native vault without the secret in plaintext")
+		}
+		v, err = gauth.LoadVault(path, func() ([]byte, error) { return passwd, nil })
+		if err != nil || v.Find("web") == nil {
+			t.Errorf("LoadVault of the saved vault: %v, want the web account", err)
+		}
+	}
+}
+
+func TestVaultSaveCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gauth.csv")
+	if err := os.WriteFile(path, []byte("vpn:ABCDEFGH:5 # office\nweb:JBSWY3DPEHPK3PXP\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := gauth.LoadVault(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vpn := v.Find("vpn")
+	for i := 0; i < 3; i++ {
+		if _, err := gauth.NextHOTP(vpn); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Update(vpn); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Save(); err != nil {
+			t.Fatalf("Save: unexpected error: %v", err)
+		}
+	}
+	if backups, err := gauth.ListBackups(path); err != nil || len(backups) != 0 {
+		t.Errorf("Backups after advancing a counter: got %+v, %v, want none", backups, err)
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "vpn:ABCDEFGH:8 # office\nweb:JBSWY3DPEHPK3PXP\n" {
+		t.Errorf("Saved vault: got %q, %v", got, err)
+	}
+
+	// Other edits are backed up, counters or not.
+	if err := v.Remove(v.Find("web")); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatalf("Save: unexpected error: %v", err)
+	}
+	if backups, err := gauth.ListBackups(path); err != nil || len(backups) != 1 {
+		t.Errorf("Backups after removing an account: got %+v, %v, want 1", backups, err)
+	}
+}
+
+func TestVaultComments(t *testing.T) {
+	const config = "# Shared vault, ask ops before editing.\n" +
+		"  # indented comment\n" +
+		"Team #1:ABCDEFGH # owner: alice\r\n" +
+		"vpn:GEZDGNBVGY3TQOJQ:3\t# hardware token\n" +
+		"otpauth://totp/Corp:admin?secret=GEZDGNBVGY3TQOJQ # break-glass account\n"
+
+	urls, err := gauth.ParseConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("ParseConfig: unexpected error: %v", err)
+	}
+	if len(urls) != 3 {
+		t.Fatalf("ParseConfig: got %d accounts, want 3", len(urls))
+	}
+	if u := urls[0]; u.Account != "Team #1" || u.RawSecret != "ABCDEFGH" {
+		t.Errorf("ParseConfig: got %q with secret %q, want Team #1 with ABCDEFGH", u.Account, u.RawSecret)
+	}
+
+	v, err := gauth.ParseVault([]byte(config))
+	if err != nil {
+		t.Fatalf("ParseVault: unexpected error: %v", err)
+	}
+	team, vpn, admin := v.Find("Team #1"), v.Find("vpn"), v.Find("admin")
+	if got := v.Comment(team); got != "owner: alice" {
+		t.Errorf("Comment: got %q, want %q", got, "owner: alice")
+	}
+	if got := v.Comment(admin); got != "break-glass account" {
+		t.Errorf("Comment: got %q, want %q", got, "break-glass account")
+	}
+
+	vpn.Counter = 4
+	if err := v.Update(vpn); err != nil {
+		t.Errorf("Update: unexpected error: %v", err)
+	}
+	if err := v.SetComment(team, "owner: bob"); err != nil {
+		t.Errorf("SetComment: unexpected error: %v", err)
+	}
+	if err := v.SetComment(admin, ""); err != nil {
+		t.Errorf("SetComment: unexpected error: %v", err)
+	}
+	if err := v.Add(&otpauth.URL{Type: "totp", Account: "new", RawSecret: "ABCDEFGH"}); err != nil {
+		t.Errorf("Add: unexpected error: %v", err)
+	}
+
+	want := "# Shared vault, ask ops before editing.\n" +
+		"  # indented comment\n" +
+		"Team #1:ABCDEFGH # owner: bob\r\n" +
+		"vpn:GEZDGNBVGY3TQOJQ:4\t# hardware token\n" +
+		"otpauth://totp/Corp:admin?secret=GEZDGNBVGY3TQOJQ\n" +
+		"new:ABCDEFGH\n"
+	if got := string(v.Bytes()); got != want {
+		t.Errorf("Bytes of the edited vault:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestVaultTags(t *testing.T) {
+	v, err := gauth.ParseVault([]byte("vpn:GEZDGNBVGY3TQOJQ:3 # hardware token\nAWS:ABCDEFGH # [prod]\n"))
+	if err != nil {
+		t.Fatalf("ParseVault: unexpected error: %v", err)
+	}
+	vpn, aws := v.Find("vpn"), v.Find("AWS")
+	if tags := v.Tags(vpn); len(tags) != 0 {
+		t.Errorf("Tags: got %q, want none", tags)
+	}
+	if err := v.SetTags(vpn, []string{"prod", "network ops"}); err != nil {
+		t.Errorf("SetTags: unexpected error: %v", err)
+	}
+	if err := v.SetTags(aws, nil); err != nil {
+		t.Errorf("SetTags: unexpected error: %v", err)
+	}
+	for _, tag := range []string{"", " padded", "a,b", "[x]", "x#y"} {
+		if err := v.SetTags(aws, []string{tag}); err == nil {
+			t.Errorf("SetTags(%q): got nil error", tag)
+		}
+	}
+
+	// Tags are comments, which survive encryption.
+	path := filepath.Join(t.TempDir(), "gauth.csv")
+	if err := gauth.WriteConfigFile(path, []byte("x"), v.Bytes()); err != nil {
+		t.Fatalf("WriteConfigFile: unexpected error: %v", err)
+	}
+	v, err = gauth.LoadVault(path, func() ([]byte, error) { return []byte("x"), nil })
+	if err != nil {
+		t.Fatalf("LoadVault: unexpected error: %v", err)
+	}
+	want := "vpn:GEZDGNBVGY3TQOJQ:3 # [prod, network ops] hardware token\nAWS:ABCDEFGH\n"
+	if got := string(v.Bytes()); got != want {
+		t.Errorf("Bytes of the tagged vault:\ngot  %q\nwant %q", got, want)
+	}
+	if tags := v.Tags(v.Find("vpn")); len(tags) != 2 || tags[0] != "prod" || tags[1] != "network ops" {
+		t.Errorf("Tags after a round trip: got %q, want [prod network ops]", tags)
+	}
+}
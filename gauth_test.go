@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pcarrier/gauth/gauth"
+)
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		alias    []string // set by a flag among the global flags
+		args     []string
+		wantCmd  string // "" for help
+		wantArgs []string
+	}{
+		// Subcommands.
+		{nil, nil, "list", nil},
+		{nil, []string{"list", "-by-tag"}, "list", []string{"-by-tag"}},
+		{nil, []string{"list", "-by-tag", "vpn"}, "list", []string{"-by-tag", "vpn"}},
+		{nil, []string{"list", "-by-issuer", "-t"}, "list", []string{"-by-issuer", "-t"}},
+		{nil, []string{"code", "Google"}, "code", []string{"Google"}},
+		{nil, []string{"add", "-b"}, "add", []string{"-b"}},
+		{nil, []string{"help"}, "", nil},
+		{nil, []string{"help", "-b"}, "", nil},
+
+		// The forms of earlier versions.
+		{nil, []string{"Google"}, "list", []string{"--", "Google"}},
+		{nil, []string{"Google", "-b"}, "code", []string{"--", "Google"}},
+		{nil, []string{"Google", "--bare"}, "code", []string{"--", "Google"}},
+		{nil, []string{"vpn", "-n"}, "next", []string{"--", "vpn"}},
+		{nil, []string{"vpn", "-t"}, "list", []string{"-by-tag", "--", "vpn"}},
+		{nil, []string{"Google", "extra"}, "list", []string{"--", "Google", "extra"}},
+		{[]string{"code"}, []string{"Google"}, "code", []string{"--", "Google"}},
+		{[]string{"list", "-by-issuer"}, nil, "list", []string{"-by-issuer", "--"}},
+		{[]string{"add"}, []string{"list"}, "add", []string{"--", "list"}},
+	}
+	for _, tc := range tests {
+		cmd, args := dispatch(tc.alias, tc.args)
+		name := ""
+		if cmd != nil {
+			name = cmd.name
+		}
+		if name != tc.wantCmd || !slices.Equal(args, tc.wantArgs) {
+			t.Errorf("dispatch(%q, %q) = %q, %q; want %q, %q", tc.alias, tc.args, name, args, tc.wantCmd, tc.wantArgs)
+		}
+	}
+}
+
+func TestCheckTransfer(t *testing.T) {
+	dir := t.TempDir()
+	plain := gauth.NewVault(filepath.Join(dir, "plain.csv"), nil)
+	encrypted := gauth.NewVault(filepath.Join(dir, "encrypted.csv"), []byte("x"))
+	tests := []struct {
+		source, target *gauth.Vault
+		ok             bool
+	}{
+		{plain, plain, true},
+		{plain, encrypted, true},
+		{encrypted, encrypted, true},
+		{encrypted, plain, false},
+	}
+	for _, tc := range tests {
+		err := checkTransfer(tc.source, tc.target, "work")
+		if (err == nil) != tc.ok {
+			t.Errorf("checkTransfer(encrypted %v, encrypted %v) = %v, want ok %v", tc.source.Encrypted(), tc.target.Encrypted(), err, tc.ok)
+		}
+	}
+}
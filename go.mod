@@ -6,6 +6,8 @@ toolchain go1.23.6
 
 require (
 	github.com/creachadair/otp v0.5.0
+	golang.org/x/crypto v0.33.0
+	golang.org/x/sys v0.30.0
 	golang.org/x/term v0.29.0
 )
 
@@ -13,5 +15,4 @@ require (
 	github.com/creachadair/mds v0.21.3 // indirect
 	github.com/creachadair/wirepb v0.0.0-20241211162510-f7f2e8a40ddc // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
-	golang.org/x/sys v0.30.0 // indirect
 )
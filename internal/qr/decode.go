@@ -0,0 +1,473 @@
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// Decode finds a QR code in img and returns its contents. It handles codes
+// drawn straight, or rotated by right angles, at any scale, as in
+// screenshots; codes photographed at an angle are not supported.
+func Decode(img image.Image) ([]byte, error) {
+	bw := binarize(img)
+	err := errors.New("qr: no QR code found")
+	for _, b := range []*bitmap{bw, bw.inverse()} {
+		finders := b.findFinders()
+		for i := 0; i < len(finders); i++ {
+			for j := i + 1; j < len(finders); j++ {
+				for k := j + 1; k < len(finders); k++ {
+					data, e := b.decodeAt(finders[i], finders[j], finders[k])
+					if e == nil {
+						return data, nil
+					}
+					err = e
+				}
+			}
+		}
+	}
+	return nil, err
+}
+
+// bitmap is a black and white image.
+type bitmap struct {
+	w, h int
+	dark []bool
+}
+
+// at reports whether the pixel at (x, y) is dark; pixels outside the image
+// are light.
+func (b *bitmap) at(x, y int) bool {
+	return x >= 0 && y >= 0 && x < b.w && y < b.h && b.dark[y*b.w+x]
+}
+
+func (b *bitmap) inverse() *bitmap {
+	inv := &bitmap{w: b.w, h: b.h, dark: make([]bool, len(b.dark))}
+	for i, d := range b.dark {
+		inv.dark[i] = !d
+	}
+	return inv
+}
+
+// binarize converts img to black and white with Otsu's threshold, drawing
+// transparent pixels over white.
+func binarize(img image.Image) *bitmap {
+	r := img.Bounds()
+	b := &bitmap{w: r.Dx(), h: r.Dy(), dark: make([]bool, r.Dx()*r.Dy())}
+	lum := make([]uint8, len(b.dark))
+	var hist [256]int
+	for y := 0; y < b.h; y++ {
+		for x := 0; x < b.w; x++ {
+			cr, cg, cb, ca := img.At(r.Min.X+x, r.Min.Y+y).RGBA()
+			white := 0xFFFF - ca
+			cr, cg, cb = cr+white, cg+white, cb+white
+			l := uint8((19595*cr + 38470*cg + 7471*cb + 1<<15) >> 24)
+			lum[y*b.w+x] = l
+			hist[l]++
+		}
+	}
+
+	total := len(lum)
+	var sum float64
+	for i, n := range hist {
+		sum += float64(i * n)
+	}
+	var sumB float64
+	var weightB int
+	threshold, best := 127, -1.0
+	for t, n := range hist {
+		weightB += n
+		if weightB == 0 {
+			continue
+		}
+		weightF := total - weightB
+		if weightF == 0 {
+			break
+		}
+		sumB += float64(t * n)
+		meanB := sumB / float64(weightB)
+		meanF := (sum - sumB) / float64(weightF)
+		if v := float64(weightB) * float64(weightF) * (meanB - meanF) * (meanB - meanF); v > best {
+			threshold, best = t, v
+		}
+	}
+	for i, l := range lum {
+		b.dark[i] = int(l) <= threshold
+	}
+	return b
+}
+
+// finder is a finder pattern candidate.
+type finder struct {
+	x, y   float64 // center, in pixels
+	module float64 // module size, in pixels
+	count  int     // number of scan lines that found it
+}
+
+// maxFinders is the number of finder pattern candidates to try.
+const maxFinders = 8
+
+// findFinders returns finder pattern candidates, the most likely first.
+func (b *bitmap) findFinders() []finder {
+	var found []finder
+	for y := 0; y < b.h; y++ {
+		var runs [5]int
+		seen := 0
+		for x := 0; x < b.w; {
+			start, dark := x, b.at(x, y)
+			for x < b.w && b.at(x, y) == dark {
+				x++
+			}
+			copy(runs[:], runs[1:])
+			runs[4] = x - start
+			seen++
+			if !dark || seen < 5 || !finderRatio(runs) {
+				continue
+			}
+			cx := int(float64(x) - float64(runs[4]+runs[3]) - float64(runs[2])/2)
+			cy, mv, ok := b.crossCheck(cx, y, 0, 1)
+			if !ok {
+				continue
+			}
+			fx, mh, ok := b.crossCheck(cx, int(cy), 1, 0)
+			if !ok {
+				continue
+			}
+			found = addFinder(found, finder{x: fx, y: cy, module: (mv + mh) / 2, count: 1})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].count > found[j].count })
+	if len(found) > maxFinders {
+		found = found[:maxFinders]
+	}
+	return found
+}
+
+// addFinder merges f into the candidate at the same place, if any.
+func addFinder(found []finder, f finder) []finder {
+	for i := range found {
+		g := &found[i]
+		if math.Abs(g.x-f.x) <= g.module && math.Abs(g.y-f.y) <= g.module &&
+			math.Abs(g.module-f.module) <= g.module/2 {
+			n := float64(g.count)
+			g.x = (g.x*n + f.x) / (n + 1)
+			g.y = (g.y*n + f.y) / (n + 1)
+			g.module = (g.module*n + f.module) / (n + 1)
+			g.count++
+			return found
+		}
+	}
+	return append(found, f)
+}
+
+// finderRatio reports whether runs, dark first, are in the 1:1:3:1:1 ratio
+// of finder patterns.
+func finderRatio(runs [5]int) bool {
+	total := 0
+	for _, r := range runs {
+		if r == 0 {
+			return false
+		}
+		total += r
+	}
+	if total < 7 {
+		return false
+	}
+	m := float64(total) / 7
+	for i, r := range runs {
+		want := m
+		if i == 2 {
+			want = 3 * m
+		}
+		if math.Abs(float64(r)-want) > want*0.6+0.5 {
+			return false
+		}
+	}
+	return true
+}
+
+// crossCheck checks that the line through (x, y) along the axis (dx, dy)
+// crosses a finder pattern there, and returns its center on that axis and
+// its module size.
+func (b *bitmap) crossCheck(x, y, dx, dy int) (center, module float64, ok bool) {
+	if !b.at(x, y) {
+		return 0, 0, false
+	}
+	var back, fwd [3]int
+	walk := func(runs *[3]int, sign, px, py int) {
+		for i := range runs {
+			dark := i%2 == 0
+			for px >= 0 && py >= 0 && px < b.w && py < b.h && b.at(px, py) == dark {
+				runs[i]++
+				px, py = px+sign*dx, py+sign*dy
+			}
+		}
+	}
+	walk(&back, -1, x, y)
+	walk(&fwd, 1, x+dx, y+dy)
+	runs := [5]int{back[2], back[1], back[0] + fwd[0], fwd[1], fwd[2]}
+	if !finderRatio(runs) {
+		return 0, 0, false
+	}
+	pos := x*dx + y*dy
+	start := pos - back[0] + 1
+	total := 0
+	for _, r := range runs {
+		total += r
+	}
+	return float64(start) + float64(runs[2])/2, float64(total) / 7, true
+}
+
+// decodeAt decodes the QR code whose finder patterns are f1, f2 and f3.
+func (b *bitmap) decodeAt(f1, f2, f3 finder) ([]byte, error) {
+	module := (f1.module + f2.module + f3.module) / 3
+	for _, f := range []finder{f1, f2, f3} {
+		if math.Abs(f.module-module) > module/2 {
+			return nil, errors.New("qr: finder patterns of different sizes")
+		}
+	}
+
+	// The top left finder is the one opposite the longest side, and the
+	// top right one follows it clockwise.
+	dist := func(f, g finder) float64 { return math.Hypot(f.x-g.x, f.y-g.y) }
+	tl, tr, bl := f1, f2, f3
+	switch d12, d13, d23 := dist(f1, f2), dist(f1, f3), dist(f2, f3); {
+	case d12 >= d13 && d12 >= d23:
+		tl, tr, bl = f3, f1, f2
+	case d13 >= d12 && d13 >= d23:
+		tl, tr, bl = f2, f1, f3
+	}
+	if (tr.x-tl.x)*(bl.y-tl.y)-(tr.y-tl.y)*(bl.x-tl.x) < 0 {
+		tr, bl = bl, tr
+	}
+
+	side := (dist(tl, tr)+dist(tl, bl))/2/module + 7
+	estimate := int(math.Round((side-17)/4))*4 + 17
+	err := errors.New("qr: no QR code found")
+	for _, delta := range []int{0, 4, -4, 8, -8} {
+		size := estimate + delta
+		if size < 21 || size > 177 {
+			continue
+		}
+		grid := make([]bool, size*size)
+		span := float64(size - 7)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				u, v := (float64(x)+0.5-3.5)/span, (float64(y)+0.5-3.5)/span
+				px := tl.x + u*(tr.x-tl.x) + v*(bl.x-tl.x)
+				py := tl.y + u*(tr.y-tl.y) + v*(bl.y-tl.y)
+				grid[y*size+x] = b.at(int(math.Floor(px)), int(math.Floor(py)))
+			}
+		}
+		data, e := decodeGrid(grid, size)
+		if e == nil {
+			return data, nil
+		}
+		err = e
+	}
+	return nil, err
+}
+
+// decodeGrid decodes the modules of a QR code of the given size.
+func decodeGrid(grid []bool, size int) ([]byte, error) {
+	version := (size - 17) / 4
+	at := func(x, y int) bool { return grid[y*size+x] }
+
+	// Read both copies of the format information, and pick the closest
+	// valid value.
+	var first, second int
+	for i := 0; i < 15; i++ {
+		var x, y int
+		switch {
+		case i < 6:
+			x, y = 8, i
+		case i < 8:
+			x, y = 8, i+1
+		case i == 8:
+			x, y = 7, 8
+		default:
+			x, y = 14-i, 8
+		}
+		if at(x, y) {
+			first |= 1 << i
+		}
+		if i < 8 {
+			x, y = size-1-i, 8
+		} else {
+			x, y = 8, size-15+i
+		}
+		if at(x, y) {
+			second |= 1 << i
+		}
+	}
+	level, mask, bestDist := L, 0, 16
+	for l := L; l <= H; l++ {
+		for m := 0; m < 8; m++ {
+			info := formatInfo(l, m)
+			for _, read := range []int{first, second} {
+				if d := popcount(info ^ read); d < bestDist {
+					level, mask, bestDist = l, m, d
+				}
+			}
+		}
+	}
+	if bestDist > 3 {
+		return nil, errors.New("qr: unreadable format information")
+	}
+
+	c := newCode(version, level)
+	c.drawFunctionPatterns()
+	raw := make([]byte, numRawDataModules(version)/8)
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = size - 1 - vert
+				}
+				if c.function[y*size+x] || i >= len(raw)*8 {
+					continue
+				}
+				if at(x, y) != maskBit(mask, x, y) {
+					raw[i>>3] |= 0x80 >> (i & 7)
+				}
+				i++
+			}
+		}
+	}
+
+	// Undo the interleaving of blocks and correct them.
+	numBlocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	numShortBlocks := numBlocks - len(raw)%numBlocks
+	shortDataLen := len(raw)/numBlocks - eccLen
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortDataLen; i++ {
+		for j := range blocks {
+			if i < shortDataLen || j >= numShortBlocks {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], raw[k])
+			k++
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		if err := rsCorrect(block, eccLen); err != nil {
+			return nil, err
+		}
+		data = append(data, block[:len(block)-eccLen]...)
+	}
+	return parseSegments(data, version)
+}
+
+func popcount(x int) int {
+	n := 0
+	for ; x != 0; x &= x - 1 {
+		n++
+	}
+	return n
+}
+
+const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// parseSegments returns the contents of the segments in data.
+func parseSegments(data []byte, version int) ([]byte, error) {
+	r := bitReader{data: data}
+	var out []byte
+	for r.remaining() >= 4 {
+		mode := r.read(4)
+		switch mode {
+		case modeTerminator:
+			return out, nil
+		case modeNumeric:
+			n := r.read(countBits(mode, version))
+			for ; n >= 3; n -= 3 {
+				out = fmt.Appendf(out, "%03d", r.read(10))
+			}
+			switch n {
+			case 2:
+				out = fmt.Appendf(out, "%02d", r.read(7))
+			case 1:
+				out = fmt.Appendf(out, "%d", r.read(4))
+			}
+		case modeAlphanumeric:
+			n := r.read(countBits(mode, version))
+			for ; n >= 2; n -= 2 {
+				v := r.read(11)
+				if v >= 45*45 {
+					return nil, errors.New("qr: invalid alphanumeric segment")
+				}
+				out = append(out, alphanumeric[v/45], alphanumeric[v%45])
+			}
+			if n == 1 {
+				v := r.read(6)
+				if v >= 45 {
+					return nil, errors.New("qr: invalid alphanumeric segment")
+				}
+				out = append(out, alphanumeric[v])
+			}
+		case modeByte:
+			n := r.read(countBits(mode, version))
+			for ; n > 0; n-- {
+				out = append(out, byte(r.read(8)))
+			}
+		case modeECI:
+			// The character set is assumed to be UTF-8 anyway.
+			switch v := r.read(8); {
+			case v&0x80 == 0:
+			case v&0xC0 == 0x80:
+				r.read(8)
+			default:
+				r.read(16)
+			}
+		case modeStructured:
+			r.read(16)
+		case modeFNC1First:
+		case modeFNC1Second:
+			r.read(8)
+		default:
+			return nil, fmt.Errorf("qr: unsupported mode %d", mode)
+		}
+		if r.overflow {
+			return nil, errors.New("qr: truncated segment")
+		}
+	}
+	return out, nil
+}
+
+// bitReader reads bits, most significant first.
+type bitReader struct {
+	data     []byte
+	pos      int
+	overflow bool // set when reading past the end
+}
+
+func (r *bitReader) remaining() int { return len(r.data)*8 - r.pos }
+
+func (r *bitReader) read(n int) int {
+	if n > r.remaining() {
+		r.overflow = true
+		r.pos = len(r.data) * 8
+		return 0
+	}
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | int(r.data[r.pos>>3]>>(7-r.pos&7)&1)
+		r.pos++
+	}
+	return v
+}
@@ -0,0 +1,485 @@
+// Package qr encodes and renders QR codes, as specified in ISO/IEC 18004.
+// Only byte mode is supported, which is all otpauth URLs need.
+package qr
+
+import (
+	"errors"
+	"math"
+)
+
+// Level is an error correction level.
+type Level int
+
+const (
+	L Level = iota // recovers 7% of the symbol
+	M              // recovers 15% of the symbol
+	Q              // recovers 25% of the symbol
+	H              // recovers 30% of the symbol
+)
+
+// formatBits returns the value of l in format information.
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+// eccPerBlock and eccBlocks give the number of error correction codewords
+// in each block, and the number of blocks, by level and version.
+var (
+	eccPerBlock = [4][41]int{
+		{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	eccBlocks = [4][41]int{
+		{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+)
+
+// A Code is a QR code symbol.
+type Code struct {
+	Size    int // modules per side
+	Version int // 1 to 40
+	Level   Level
+
+	modules  []bool // row by row, true for dark modules
+	function []bool // modules that are not part of the data area
+}
+
+// Black reports whether the module at column x and row y is dark.
+func (c *Code) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y*c.Size+x]
+}
+
+// Encode returns the smallest QR code holding data in byte mode at the given
+// error correction level.
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < L || level > H {
+		return nil, errors.New("qr: invalid error correction level")
+	}
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if 4+countBits(modeByte, v)+8*len(data) <= 8*numDataCodewords(v, level) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("qr: data too long")
+	}
+
+	// Byte mode indicator, character count, data, and a terminator of up to
+	// four zero bits, padded to a whole number of codewords.
+	var bits bitBuffer
+	bits.append(modeByte, 4)
+	bits.append(len(data), countBits(modeByte, version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := 8 * numDataCodewords(version, level)
+	bits.append(0, min(4, capacity-bits.n))
+	bits.append(0, (8-bits.n%8)%8)
+	codewords := bits.bytes()
+	for pad := byte(0xEC); len(codewords) < capacity/8; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	c := newCode(version, level)
+	c.drawFunctionPatterns()
+	c.drawCodewords(addECC(codewords, version, level))
+
+	best, bestPenalty := -1, math.MaxInt
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if p := c.penalty(); p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // XOR again to undo
+	}
+	c.applyMask(best)
+	c.drawFormatBits(best)
+	return c, nil
+}
+
+// Mode indicators of segments.
+const (
+	modeTerminator   = 0
+	modeNumeric      = 1
+	modeAlphanumeric = 2
+	modeStructured   = 3
+	modeByte         = 4
+	modeFNC1First    = 5
+	modeECI          = 7
+	modeKanji        = 8
+	modeFNC1Second   = 9
+)
+
+// countBits returns the length of the character count of a segment.
+func countBits(mode, version int) int {
+	i := 0
+	if version >= 27 {
+		i = 2
+	} else if version >= 10 {
+		i = 1
+	}
+	switch mode {
+	case modeNumeric:
+		return [...]int{10, 12, 14}[i]
+	case modeAlphanumeric:
+		return [...]int{9, 11, 13}[i]
+	case modeKanji:
+		return [...]int{8, 10, 12}[i]
+	default:
+		return [...]int{8, 16, 16}[i]
+	}
+}
+
+// numRawDataModules returns the number of modules available for data and
+// error correction codewords in a symbol of the given version.
+func numRawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		n -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// numDataCodewords returns the number of data codewords in a symbol.
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 - eccPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// addECC splits data into blocks, computes their error correction codewords,
+// and interleaves the result.
+func addECC(data []byte, version int, level Level) []byte {
+	numBlocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortDataLen := rawCodewords/numBlocks - eccLen
+
+	gen := rsGenerator(eccLen)
+	var dataBlocks, eccs [][]byte
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortDataLen
+		if i >= numShortBlocks {
+			n++
+		}
+		dataBlocks = append(dataBlocks, data[k:k+n])
+		eccs = append(eccs, rsRemainder(data[k:k+n], gen))
+		k += n
+	}
+
+	out := make([]byte, 0, rawCodewords)
+	for i := 0; i <= shortDataLen; i++ {
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for _, e := range eccs {
+			out = append(out, e[i])
+		}
+	}
+	return out
+}
+
+func newCode(version int, level Level) *Code {
+	size := 4*version + 17
+	return &Code{
+		Size:     size,
+		Version:  version,
+		Level:    level,
+		modules:  make([]bool, size*size),
+		function: make([]bool, size*size),
+	}
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	pos := alignmentPositions(c.Version)
+	for i, x := range pos {
+		for j, y := range pos {
+			// Skip the corners taken by finder patterns.
+			last := len(pos) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+
+	// Reserve the format areas; drawFormatBits fills them in.
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// drawFinder draws a finder pattern and its separator around (x, y).
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions returns the coordinates of the centers of alignment
+// patterns, which are used both as rows and as columns.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + numAlign*2 + 1) / (numAlign*2 - 2) * 2
+	}
+	out := make([]int, numAlign)
+	out[0] = 6
+	for i, pos := numAlign-1, 4*version+10; i >= 1; i, pos = i-1, pos-step {
+		out[i] = pos
+	}
+	return out
+}
+
+// formatInfo returns the 15 bits of format information for level and mask.
+func formatInfo(level Level, mask int) int {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatInfo(c.Level, mask)
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true) // always dark
+}
+
+// versionInfo returns the 18 bits of version information.
+func versionInfo(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	return version<<12 | rem
+}
+
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	bits := versionInfo(c.Version)
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places data in the zigzag order of the data area.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert // upward
+				}
+				if !c.function[y*c.Size+x] && i < len(data)*8 {
+					c.modules[y*c.Size+x] = data[i>>3]>>(7-i&7)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// maskBit reports whether mask pattern mask inverts the module at (x, y).
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.function[y*c.Size+x] && maskBit(mask, x, y) {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+// penalty scores c by the rules of ISO/IEC 18004 section 7.8.3; the mask
+// with the lowest score is used.
+func (c *Code) penalty() int {
+	n := c.Size
+	p := 0
+	dark := 0
+	for i := 0; i < n; i++ {
+		p += c.linePenalty(func(j int) bool { return c.modules[i*n+j] })
+		p += c.linePenalty(func(j int) bool { return c.modules[j*n+i] })
+	}
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			m := c.modules[y*n+x]
+			if m {
+				dark++
+			}
+			if x < n-1 && y < n-1 && m == c.modules[y*n+x+1] && m == c.modules[(y+1)*n+x] && m == c.modules[(y+1)*n+x+1] {
+				p += 3
+			}
+		}
+	}
+	total := n * n
+	p += abs(dark*20-total*10) / total * 10
+	return p
+}
+
+// linePenalty scores runs of modules of the same color, and patterns that
+// look like finders, in a row or column.
+func (c *Code) linePenalty(at func(int) bool) int {
+	p := 0
+	run := 0
+	for j := 0; j < c.Size; j++ {
+		if j > 0 && at(j) == at(j-1) {
+			run++
+		} else {
+			run = 1
+		}
+		if run == 5 {
+			p += 3
+		} else if run > 5 {
+			p++
+		}
+	}
+
+	light := func(j int) bool { return j < 0 || j >= c.Size || !at(j) }
+	finder := [7]bool{true, false, true, true, true, false, true}
+	for j := 0; j+7 <= c.Size; j++ {
+		match := true
+		for k, d := range finder {
+			if at(j+k) != d {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		before, after := true, true
+		for k := 1; k <= 4; k++ {
+			before = before && light(j-k)
+			after = after && light(j+6+k)
+		}
+		if before || after {
+			p += 40
+		}
+	}
+	return p
+}
+
+// bitBuffer accumulates bits, most significant first.
+type bitBuffer struct {
+	data []byte
+	n    int
+}
+
+func (b *bitBuffer) append(v, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.data = append(b.data, 0)
+		}
+		if v>>i&1 != 0 {
+			b.data[b.n/8] |= 0x80 >> (b.n % 8)
+		}
+		b.n++
+	}
+}
+
+func (b *bitBuffer) bytes() []byte { return b.data }
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
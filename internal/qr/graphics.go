@@ -0,0 +1,86 @@
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"strings"
+)
+
+// Sixel renders c as a sixel image, for terminals that support DEC sixel
+// graphics, with scale pixels per module.
+func (c *Code) Sixel(scale int) string {
+	if scale < 1 {
+		scale = 1
+	}
+	side := (c.Size + 2*QuietZone) * scale
+	var b strings.Builder
+	// Color 0 is white and color 1 is black, in percents of RGB.
+	fmt.Fprintf(&b, "\x1bPq\"1;1;%d;%d#0;2;100;100;100#1;2;0;0;0", side, side)
+	for band := 0; band < side; band += 6 {
+		for color := 0; color < 2; color++ {
+			fmt.Fprintf(&b, "#%d", color)
+			var last byte
+			run := 0
+			flush := func() {
+				switch {
+				case run > 3:
+					fmt.Fprintf(&b, "!%d%c", run, last)
+				case run > 0:
+					b.WriteString(strings.Repeat(string(last), run))
+				}
+			}
+			for x := 0; x < side; x++ {
+				bits := 0
+				for dy := 0; dy < 6 && band+dy < side; dy++ {
+					if c.Black(x/scale-QuietZone, (band+dy)/scale-QuietZone) == (color == 1) {
+						bits |= 1 << dy
+					}
+				}
+				ch := byte(63 + bits)
+				if ch != last {
+					flush()
+					last, run = ch, 0
+				}
+				run++
+			}
+			flush()
+			if color == 0 {
+				b.WriteByte('$') // back to the start of the band
+			}
+		}
+		b.WriteByte('-') // next band
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// Kitty renders c as an image for terminals that support the kitty graphics
+// protocol, with scale pixels per module.
+func (c *Code) Kitty(scale int) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(scale)); err != nil {
+		panic(err) // writing to a bytes.Buffer cannot fail
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	// The protocol takes at most 4096 bytes per escape sequence.
+	const chunk = 4096
+	var b strings.Builder
+	for i := 0; i < len(data); i += chunk {
+		more := 0
+		if i+chunk < len(data) {
+			more = 1
+		}
+		if i == 0 {
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,m=%d;", more)
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;", more)
+		}
+		b.WriteString(data[i:min(i+chunk, len(data))])
+		b.WriteString("\x1b\\")
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
@@ -0,0 +1,319 @@
+package qr
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// Version 1-M symbol for "01234567", from ISO/IEC 18004 annex I.
+	data := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	want := []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}
+	if got := rsRemainder(data, rsGenerator(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder() = %X, want %X", got, want)
+	}
+}
+
+func TestFormatInfo(t *testing.T) {
+	for _, tt := range []struct {
+		level Level
+		mask  int
+		want  int
+	}{
+		{L, 0, 0b111011111000100},
+		{L, 7, 0b110100101110110},
+		{M, 0, 0b101010000010010},
+		{M, 5, 0b100000011001110},
+		{Q, 0, 0b011010101011111},
+		{H, 0, 0b001011010001001},
+		{H, 7, 0b000100000111011},
+	} {
+		if got := formatInfo(tt.level, tt.mask); got != tt.want {
+			t.Errorf("formatInfo(%d, %d) = %015b, want %015b", tt.level, tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	for _, tt := range []struct{ version, want int }{
+		{7, 0x07C94},
+		{8, 0x085BC},
+		{21, 0x15683},
+		{40, 0x28C69},
+	} {
+		if got := versionInfo(tt.version); got != tt.want {
+			t.Errorf("versionInfo(%d) = %05X, want %05X", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	// Byte mode capacities from ISO/IEC 18004 table 7.
+	for _, tt := range []struct {
+		version int
+		level   Level
+		bytes   int
+	}{
+		{1, L, 17},
+		{1, H, 7},
+		{2, M, 26},
+		{6, Q, 74},
+		{7, Q, 86},
+		{10, L, 271},
+		{10, H, 119},
+		{27, M, 1125},
+		{40, L, 2953},
+		{40, H, 1273},
+	} {
+		c, err := Encode(make([]byte, tt.bytes), tt.level)
+		if err != nil {
+			t.Errorf("Encode(%d bytes, %d): %v", tt.bytes, tt.level, err)
+		} else if c.Version != tt.version {
+			t.Errorf("Encode(%d bytes, %d) = version %d, want %d", tt.bytes, tt.level, c.Version, tt.version)
+		}
+		if c, err := Encode(make([]byte, tt.bytes+1), tt.level); err == nil && c.Version == tt.version {
+			t.Errorf("Encode(%d bytes, %d) fits version %d", tt.bytes+1, tt.level, tt.version)
+		}
+	}
+	if _, err := Encode(make([]byte, 2954), L); err == nil {
+		t.Error("Encode(2954 bytes, L) succeeded")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	data := []byte("otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example")
+	c, err := Encode(data, M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size != 4*c.Version+17 {
+		t.Errorf("Size = %d for version %d", c.Size, c.Version)
+	}
+
+	// Finder patterns in three corners, with their separators.
+	for _, corner := range [][2]int{{0, 0}, {c.Size - 7, 0}, {0, c.Size - 7}} {
+		for dy := -1; dy <= 7; dy++ {
+			for dx := -1; dx <= 7; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+					continue
+				}
+				d := max(abs(dx-3), abs(dy-3))
+				if want := d != 2 && d != 4; c.Black(x, y) != want {
+					t.Errorf("module (%d, %d) = %v, want %v", x, y, c.Black(x, y), want)
+				}
+			}
+		}
+	}
+	// Timing patterns.
+	for i := 8; i < c.Size-8; i++ {
+		if c.Black(i, 6) != (i%2 == 0) || c.Black(6, i) != (i%2 == 0) {
+			t.Errorf("timing pattern broken at %d", i)
+		}
+	}
+	// Both copies of the format information must agree.
+	var first, second int
+	for i := 0; i < 15; i++ {
+		var x, y int
+		switch {
+		case i < 6:
+			x, y = 8, i
+		case i < 8:
+			x, y = 8, i+1
+		case i == 8:
+			x, y = 7, 8
+		default:
+			x, y = 14-i, 8
+		}
+		if c.Black(x, y) {
+			first |= 1 << i
+		}
+		if i < 8 {
+			x, y = c.Size-1-i, 8
+		} else {
+			x, y = 8, c.Size-15+i
+		}
+		if c.Black(x, y) {
+			second |= 1 << i
+		}
+	}
+	if first != second {
+		t.Errorf("format information copies differ: %015b and %015b", first, second)
+	}
+	found := false
+	for mask := 0; mask < 8; mask++ {
+		if formatInfo(M, mask) == first {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("format information %015b is not for level M", first)
+	}
+	if !c.Black(8, c.Size-8) {
+		t.Error("dark module is light")
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	c, err := Encode([]byte("gauth"), L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(c.Terminal(false), "\n"), "\n")
+	side := c.Size + 2*QuietZone
+	if len(lines) != (side+1)/2 {
+		t.Errorf("%d lines, want %d", len(lines), (side+1)/2)
+	}
+	for _, line := range lines {
+		if n := len([]rune(line)); n != side {
+			t.Errorf("line has %d cells, want %d", n, side)
+		}
+	}
+	// The top left finder is dark, which is blank unless inverted.
+	if r := []rune(lines[QuietZone/2])[QuietZone]; r != ' ' {
+		t.Errorf("finder corner drawn as %q", r)
+	}
+	if r := []rune(strings.Split(c.Terminal(true), "\n")[QuietZone/2])[QuietZone]; r != '█' {
+		t.Errorf("inverted finder corner drawn as %q", r)
+	}
+
+	img := c.Image(3)
+	if b := img.Bounds(); b.Dx() != 3*side || b.Dy() != 3*side {
+		t.Errorf("image is %v, want %d pixels wide", b, 3*side)
+	}
+}
+
+func TestReedSolomonCorrection(t *testing.T) {
+	data := []byte("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP")
+	const eccLen = 16
+	block := append(append([]byte{}, data...), rsRemainder(data, rsGenerator(eccLen))...)
+	for errs := 0; errs <= eccLen/2; errs++ {
+		damaged := append([]byte{}, block...)
+		for i := 0; i < errs; i++ {
+			damaged[i*5] ^= byte(0x5A + i)
+		}
+		if err := rsCorrect(damaged, eccLen); err != nil {
+			t.Errorf("rsCorrect with %d errors: %v", errs, err)
+		} else if !bytes.Equal(damaged, block) {
+			t.Errorf("rsCorrect with %d errors: got %q", errs, damaged[:len(data)])
+		}
+	}
+	damaged := append([]byte{}, block...)
+	for i := 0; i <= eccLen/2; i++ {
+		damaged[i*5] ^= 0xFF
+	}
+	if err := rsCorrect(damaged, eccLen); err == nil && bytes.Equal(damaged, block) {
+		t.Error("rsCorrect corrected more errors than it can")
+	}
+}
+
+func TestParseSegments(t *testing.T) {
+	// Numeric segment from the ISO/IEC 18004 annex I example.
+	data := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11}
+	if got, err := parseSegments(data, 1); err != nil || string(got) != "01234567" {
+		t.Errorf("parseSegments(numeric) = %q, %v; want \"01234567\"", got, err)
+	}
+	// Alphanumeric "AC-42": mode 0010, count 000000101, then 11 bit pairs
+	// and a 6 bit single character, and a terminator.
+	var bits bitBuffer
+	bits.append(modeAlphanumeric, 4)
+	bits.append(5, 9)
+	bits.append(10*45+12, 11)
+	bits.append(41*45+4, 11)
+	bits.append(2, 6)
+	bits.append(0, 4)
+	if got, err := parseSegments(bits.bytes(), 1); err != nil || string(got) != "AC-42" {
+		t.Errorf("parseSegments(alphanumeric) = %q, %v; want \"AC-42\"", got, err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, tt := range []struct {
+		data  string
+		level Level
+		scale int
+	}{
+		{"gauth", L, 1},
+		{"otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example", M, 3},
+		{strings.Repeat("otpauth-migration://offline?data=CjEKCkhlbGxvId6tvu8", 10), L, 2},
+		{strings.Repeat("0123456789", 50), H, 4},
+	} {
+		c, err := Encode([]byte(tt.data), tt.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := c.Image(tt.scale)
+		for turn := 0; turn < 4; turn++ {
+			got, err := Decode(img)
+			if err != nil {
+				t.Errorf("Decode(version %d, turned %d times): %v", c.Version, turn, err)
+			} else if string(got) != tt.data {
+				t.Errorf("Decode(version %d, turned %d times) = %q, want %q", c.Version, turn, got, tt.data)
+			}
+			img = rotate(img)
+		}
+	}
+}
+
+func TestDecodeDamaged(t *testing.T) {
+	want := "otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP"
+	c, err := Encode([]byte(want), M)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Scribble over a few data modules, and invert the whole image.
+	img := c.Image(2).(*image.Gray)
+	for i := 0; i < 6; i++ {
+		x, y := 2*(QuietZone+c.Size-1-i), 2*(QuietZone+c.Size-1-2*i)
+		for _, p := range [][2]int{{x, y}, {x + 1, y}, {x, y + 1}, {x + 1, y + 1}} {
+			img.Pix[img.PixOffset(p[0], p[1])] ^= 0xFF
+		}
+	}
+	for i := range img.Pix {
+		img.Pix[i] ^= 0xFF
+	}
+	if got, err := Decode(img); err != nil || string(got) != want {
+		t.Errorf("Decode(damaged) = %q, %v; want %q", got, err, want)
+	}
+
+	if _, err := Decode(image.NewGray(image.Rect(0, 0, 50, 50))); err == nil {
+		t.Error("Decode(blank image): got nil error")
+	}
+}
+
+// rotate turns img a quarter clockwise.
+func rotate(img image.Image) image.Image {
+	b := img.Bounds()
+	out := image.NewGray(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			out.Set(b.Dy()-1-y, x, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return out
+}
+
+func TestGraphics(t *testing.T) {
+	c, err := Encode([]byte("gauth"), L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := c.Size + 2*QuietZone
+	sixel := c.Sixel(1)
+	if !strings.HasPrefix(sixel, "\x1bPq") || !strings.HasSuffix(sixel, "\x1b\\") {
+		t.Errorf("Sixel() is not a DCS sequence: %q", sixel)
+	}
+	if n := strings.Count(sixel, "-"); n != (side+5)/6 {
+		t.Errorf("Sixel() has %d bands, want %d", n, (side+5)/6)
+	}
+
+	kitty := c.Kitty(40)
+	if !strings.HasPrefix(kitty, "\x1b_Ga=T,f=100,m=") {
+		t.Errorf("Kitty() does not start with a transmission: %q", kitty[:20])
+	}
+	if strings.Count(kitty, "m=0;") != 1 || !strings.HasSuffix(kitty, "\x1b\\\n") {
+		t.Errorf("Kitty() does not end with one final chunk")
+	}
+}
@@ -0,0 +1,58 @@
+package qr
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// QuietZone is the width, in modules, of the light border that scanners need
+// around a code.
+const QuietZone = 4
+
+// Terminal renders c as text, using Unicode half blocks to fit two rows of
+// modules in each line. Light modules are drawn in the foreground color,
+// which suits terminals with a light text on a dark background; invert
+// swaps that for dark text on a light background.
+func (c *Code) Terminal(invert bool) string {
+	light := func(x, y int) bool { return c.Black(x, y) == invert }
+	var b strings.Builder
+	for y := -QuietZone; y < c.Size+QuietZone; y += 2 {
+		for x := -QuietZone; x < c.Size+QuietZone; x++ {
+			top := light(x, y)
+			bottom := y+1 < c.Size+QuietZone && light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Image renders c as a grayscale image, with scale pixels per module and a
+// quiet zone.
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	side := (c.Size + 2*QuietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for py := 0; py < side; py++ {
+		for px := 0; px < side; px++ {
+			v := color.Gray{Y: 0xFF}
+			if c.Black(px/scale-QuietZone, py/scale-QuietZone) {
+				v.Y = 0
+			}
+			img.SetGray(px, py, v)
+		}
+	}
+	return img
+}
@@ -0,0 +1,157 @@
+package qr
+
+// Reed-Solomon error correction over GF(2^8) with the QR polynomial
+// x^8 + x^4 + x^3 + x^2 + 1.
+
+import "errors"
+
+// gfMul multiplies x and y in GF(2^8).
+func gfMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		hi := z >> 7
+		z = z<<1 ^ hi*0x1D
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// rsGenerator returns the coefficients of the generator polynomial of the
+// given degree, highest power first, without the leading 1.
+func rsGenerator(degree int) []byte {
+	gen := make([]byte, degree)
+	gen[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		// Multiply by (x - root).
+		for j := range gen {
+			gen[j] = gfMul(gen[j], root)
+			if j+1 < len(gen) {
+				gen[j] ^= gen[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return gen
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, gen []byte) []byte {
+	rem := make([]byte, len(gen))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i, g := range gen {
+			rem[i] ^= gfMul(g, factor)
+		}
+	}
+	return rem
+}
+
+// gfExp and gfLog are the powers and logarithms of the generator 2.
+var gfExp, gfLog = func() (exp [510]byte, log [256]int) {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = x, x
+		log[x] = i
+		x = gfMul(x, 2)
+	}
+	return
+}()
+
+func gfInv(x byte) byte { return gfExp[255-gfLog[x]] }
+
+// gfPow returns 2 raised to the power n.
+func gfPow(n int) byte { return gfExp[n%255] }
+
+// polyEval evaluates p, lowest power first, at x.
+func polyEval(p []byte, x byte) byte {
+	var y byte
+	for i := len(p) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ p[i]
+	}
+	return y
+}
+
+// rsCorrect corrects the errors in block, made of data followed by eccLen
+// error correction codewords, if there are at most eccLen/2 of them.
+func rsCorrect(block []byte, eccLen int) error {
+	n := len(block)
+	syndromes := make([]byte, eccLen)
+	clean := true
+	for i := range syndromes {
+		x := gfPow(i)
+		var s byte
+		for _, b := range block {
+			s = gfMul(s, x) ^ b
+		}
+		syndromes[i] = s
+		clean = clean && s == 0
+	}
+	if clean {
+		return nil
+	}
+
+	// Berlekamp-Massey finds the error locator, lowest power first.
+	locator, prev := []byte{1}, []byte{1}
+	errs, shift, lastDisc := 0, 1, byte(1)
+	for k := 0; k < eccLen; k++ {
+		d := syndromes[k]
+		for i := 1; i <= errs && i < len(locator); i++ {
+			d ^= gfMul(locator[i], syndromes[k-i])
+		}
+		if d == 0 {
+			shift++
+			continue
+		}
+		next := make([]byte, max(len(locator), len(prev)+shift))
+		copy(next, locator)
+		f := gfMul(d, gfInv(lastDisc))
+		for i, p := range prev {
+			next[i+shift] ^= gfMul(f, p)
+		}
+		if 2*errs <= k {
+			prev, errs, lastDisc, shift = locator, k+1-errs, d, 1
+		} else {
+			shift++
+		}
+		locator = next
+	}
+	if 2*errs > eccLen {
+		return errors.New("qr: too many errors")
+	}
+
+	// Chien search for the positions of the errors, and Forney's algorithm
+	// for their values.
+	evaluator := make([]byte, eccLen)
+	for i, s := range syndromes {
+		for j, l := range locator {
+			if i+j < eccLen {
+				evaluator[i+j] ^= gfMul(s, l)
+			}
+		}
+	}
+	derivative := make([]byte, len(locator))
+	for i := 1; i < len(locator); i += 2 {
+		derivative[i-1] = locator[i]
+	}
+	found := 0
+	for k := 0; k < n; k++ {
+		power := n - 1 - k
+		xInv := gfPow(255 - power%255)
+		if polyEval(locator, xInv) != 0 {
+			continue
+		}
+		den := polyEval(derivative, xInv)
+		if den == 0 {
+			return errors.New("qr: uncorrectable errors")
+		}
+		block[k] ^= gfMul(gfPow(power), gfMul(polyEval(evaluator, xInv), gfInv(den)))
+		found++
+	}
+	if found != errs {
+		return errors.New("qr: uncorrectable errors")
+	}
+	return nil
+}
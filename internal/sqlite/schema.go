@@ -0,0 +1,140 @@
+package sqlite
+
+import (
+	"errors"
+	"strings"
+)
+
+// A schema describes the columns of a table.
+type schema struct {
+	columns []string
+	real    []bool // whether each column has REAL affinity
+	pk      int    // index of the INTEGER PRIMARY KEY column, or -1
+}
+
+// parseSchema parses the column definitions of a CREATE TABLE statement.
+func parseSchema(sql string) (*schema, error) {
+	open := strings.IndexByte(sql, '(')
+	end := strings.LastIndexByte(sql, ')')
+	if open < 0 || end < open {
+		return nil, errors.New("sqlite: unsupported table definition")
+	}
+	s := &schema{pk: -1}
+	for _, def := range splitDefs(sql[open+1 : end]) {
+		tokens := tokenize(def)
+		if len(tokens) == 0 {
+			continue
+		}
+		switch strings.ToUpper(tokens[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue // table constraint
+		}
+		rest := strings.ToUpper(strings.Join(tokens[1:], " "))
+		typ := rest
+		for _, kw := range []string{"CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK", "DEFAULT", "COLLATE", "REFERENCES", "GENERATED", "AS"} {
+			if i := indexWord(typ, kw); i >= 0 {
+				typ = typ[:i]
+			}
+		}
+		if strings.TrimSpace(typ) == "INTEGER" && strings.Contains(rest, "PRIMARY KEY") && !strings.Contains(rest, "PRIMARY KEY DESC") {
+			s.pk = len(s.columns)
+		}
+		s.columns = append(s.columns, unquote(tokens[0]))
+		s.real = append(s.real, realAffinity(typ))
+	}
+	if len(s.columns) == 0 {
+		return nil, errors.New("sqlite: table without columns")
+	}
+	return s, nil
+}
+
+// realAffinity reports whether a column of declared type typ has REAL
+// affinity, following the rules of section 3.1 of
+// https://www.sqlite.org/datatype3.html.
+func realAffinity(typ string) bool {
+	for _, s := range []string{"INT", "CHAR", "CLOB", "TEXT", "BLOB"} {
+		if strings.Contains(typ, s) {
+			return false
+		}
+	}
+	return strings.Contains(typ, "REAL") || strings.Contains(typ, "FLOA") || strings.Contains(typ, "DOUB")
+}
+
+// indexWord returns the index of the first occurrence of word in s, whose
+// words are separated by single spaces, or -1.
+func indexWord(s, word string) int {
+	return strings.Index(" "+s+" ", " "+word+" ")
+}
+
+// splitDefs splits the body of a CREATE TABLE statement on the commas that
+// are outside of parentheses and quotes.
+func splitDefs(body string) []string {
+	var defs []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, body[start:i])
+			start = i + 1
+		}
+	}
+	return append(defs, body[start:])
+}
+
+// tokenize splits a column definition into words, keeping quoted
+// identifiers whole.
+func tokenize(def string) []string {
+	var tokens []string
+	def = strings.TrimSpace(def)
+	for def != "" {
+		var end int
+		switch def[0] {
+		case '"', '`', '\'':
+			end = strings.IndexByte(def[1:], def[0]) + 2
+		case '[':
+			end = strings.IndexByte(def, ']') + 1
+		default:
+			end = strings.IndexAny(def, " \t\r\n(")
+			if end == 0 {
+				end = 1
+			}
+		}
+		if end <= 0 || end > len(def) {
+			end = len(def)
+		}
+		tokens = append(tokens, def[:end])
+		def = strings.TrimSpace(def[end:])
+	}
+	return tokens
+}
+
+// unquote removes the quotes around an identifier.
+func unquote(s string) string {
+	if len(s) >= 2 {
+		switch s[0] {
+		case '"', '`', '\'':
+			if s[len(s)-1] == s[0] {
+				return strings.ReplaceAll(s[1:len(s)-1], s[:1]+s[:1], s[:1])
+			}
+		case '[':
+			if s[len(s)-1] == ']' {
+				return s[1 : len(s)-1]
+			}
+		}
+	}
+	return s
+}
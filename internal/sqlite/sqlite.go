@@ -0,0 +1,417 @@
+// Package sqlite reads the tables of SQLite databases, as described in
+// https://www.sqlite.org/fileformat.html, including pages still in a
+// write-ahead log. It does not write databases, and does not use indexes.
+package sqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+const header = "SQLite format 3\x00"
+
+// Page types of b-tree pages.
+const (
+	interiorTable = 0x05
+	leafTable     = 0x0D
+)
+
+// A DB is an SQLite database held in memory.
+type DB struct {
+	data     []byte
+	pageSize int
+	usable   int            // bytes of each page not reserved for extensions
+	pages    int            // number of pages
+	wal      map[int][]byte // pages from the write-ahead log, by number
+	encoding int            // 1 for UTF-8, 2 for UTF-16le, 3 for UTF-16be
+}
+
+// IsSQLite reports whether data starts like an SQLite database.
+func IsSQLite(data []byte) bool {
+	return len(data) >= 100 && string(data[:len(header)]) == header
+}
+
+// IsWAL reports whether data is an SQLite database in write-ahead log mode,
+// whose latest changes may only be in its -wal file.
+func IsWAL(data []byte) bool {
+	return IsSQLite(data) && data[18] == 2 && data[19] == 2
+}
+
+// Open returns the database of the file in data, with the committed pages of
+// its write-ahead log wal, which may be nil.
+func Open(data, wal []byte) (*DB, error) {
+	if !IsSQLite(data) {
+		return nil, errors.New("sqlite: not an SQLite database")
+	}
+	db := &DB{
+		data:     data,
+		pageSize: int(binary.BigEndian.Uint16(data[16:])),
+		encoding: int(binary.BigEndian.Uint32(data[56:])),
+	}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, fmt.Errorf("sqlite: invalid page size %d", db.pageSize)
+	}
+	db.usable = db.pageSize - int(data[20])
+	if db.usable < 480 {
+		return nil, errors.New("sqlite: invalid reserved space")
+	}
+	switch db.encoding {
+	case 0:
+		db.encoding = 1
+	case 1, 2, 3:
+	default:
+		return nil, fmt.Errorf("sqlite: invalid text encoding %d", db.encoding)
+	}
+	db.pages = len(data) / db.pageSize
+	if len(wal) > 0 {
+		if err := db.readWAL(wal); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// readWAL loads the pages of the transactions committed in wal. Frames
+// after the last valid commit are ignored, as SQLite does.
+func (db *DB) readWAL(wal []byte) error {
+	if len(wal) < 32 {
+		return nil
+	}
+	magic := binary.BigEndian.Uint32(wal)
+	if magic&^1 != 0x377f0682 {
+		return errors.New("sqlite: invalid write-ahead log")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if magic&1 == 1 {
+		order = binary.BigEndian
+	}
+	if int(binary.BigEndian.Uint32(wal[8:])) != db.pageSize {
+		return errors.New("sqlite: write-ahead log page size mismatch")
+	}
+	s0, s1 := walChecksum(order, 0, 0, wal[:24])
+	if s0 != binary.BigEndian.Uint32(wal[24:]) || s1 != binary.BigEndian.Uint32(wal[28:]) {
+		return nil // an invalid header means an empty log
+	}
+	salts := wal[16:24]
+
+	db.wal = make(map[int][]byte)
+	pending := make(map[int][]byte)
+	for off := 32; off+24+db.pageSize <= len(wal); off += 24 + db.pageSize {
+		frame := wal[off : off+24]
+		page := wal[off+24 : off+24+db.pageSize]
+		if string(frame[8:16]) != string(salts) {
+			break
+		}
+		s0, s1 = walChecksum(order, s0, s1, frame[:8])
+		s0, s1 = walChecksum(order, s0, s1, page)
+		if s0 != binary.BigEndian.Uint32(frame[16:]) || s1 != binary.BigEndian.Uint32(frame[20:]) {
+			break
+		}
+		pending[int(binary.BigEndian.Uint32(frame))] = page
+		if size := int(binary.BigEndian.Uint32(frame[4:])); size != 0 {
+			for n, p := range pending {
+				db.wal[n] = p
+			}
+			clear(pending)
+			db.pages = size
+		}
+	}
+	return nil
+}
+
+// walChecksum continues the checksum s0, s1 of a write-ahead log over b.
+func walChecksum(order binary.ByteOrder, s0, s1 uint32, b []byte) (uint32, uint32) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+// page returns page n, numbered from 1.
+func (db *DB) page(n int) ([]byte, error) {
+	if n < 1 || n > db.pages {
+		return nil, fmt.Errorf("sqlite: page %d out of range", n)
+	}
+	if p, ok := db.wal[n]; ok {
+		return p, nil
+	}
+	off := (n - 1) * db.pageSize
+	if off+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("sqlite: page %d beyond the end of the file", n)
+	}
+	return db.data[off : off+db.pageSize], nil
+}
+
+// Table returns the names of the columns of the table name, and the values
+// of its rows, in the order of their row IDs. Values are nil, int64,
+// float64, string or []byte. Rows written before columns were added to the
+// table have nil values for those columns, whatever their defaults.
+func (db *DB) Table(name string) (columns []string, rows [][]any, err error) {
+	var root int
+	var sql string
+	err = db.scan(1, make(map[int]bool), func(rowid int64, record []any) error {
+		if len(record) < 5 {
+			return errors.New("sqlite: invalid schema")
+		}
+		typ, _ := record[0].(string)
+		tbl, _ := record[1].(string)
+		if typ == "table" && strings.EqualFold(tbl, name) {
+			r, _ := record[3].(int64)
+			root = int(r)
+			sql, _ = record[4].(string)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if root == 0 {
+		return nil, nil, fmt.Errorf("sqlite: no table %q", name)
+	}
+	s, err := parseSchema(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = db.scan(root, make(map[int]bool), func(rowid int64, record []any) error {
+		row := make([]any, len(s.columns))
+		copy(row, record)
+		for i, v := range row {
+			// SQLite stores whole REAL values as integers.
+			if n, ok := v.(int64); ok && s.real[i] {
+				row[i] = float64(n)
+			}
+		}
+		if s.pk >= 0 {
+			// INTEGER PRIMARY KEY columns hold the row ID, and NULL in records.
+			row[s.pk] = rowid
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.columns, rows, nil
+}
+
+// scan calls f for each row of the table b-tree rooted at page n. Pages
+// already seen are rejected, so that corrupted databases cannot loop.
+func (db *DB) scan(n int, seen map[int]bool, f func(rowid int64, record []any) error) error {
+	if seen[n] {
+		return errors.New("sqlite: b-tree loop")
+	}
+	seen[n] = true
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	hdr := page
+	if n == 1 {
+		hdr = page[100:]
+	}
+	cells := int(binary.BigEndian.Uint16(hdr[3:]))
+	switch hdr[0] {
+	case interiorTable:
+		ptrs := hdr[12:]
+		if len(ptrs) < 2*cells {
+			return errors.New("sqlite: invalid b-tree page")
+		}
+		for i := 0; i < cells; i++ {
+			off := int(binary.BigEndian.Uint16(ptrs[2*i:]))
+			if off+4 > len(page) {
+				return errors.New("sqlite: invalid cell pointer")
+			}
+			if err := db.scan(int(binary.BigEndian.Uint32(page[off:])), seen, f); err != nil {
+				return err
+			}
+		}
+		return db.scan(int(binary.BigEndian.Uint32(hdr[8:])), seen, f)
+	case leafTable:
+		ptrs := hdr[8:]
+		if len(ptrs) < 2*cells {
+			return errors.New("sqlite: invalid b-tree page")
+		}
+		for i := 0; i < cells; i++ {
+			off := int(binary.BigEndian.Uint16(ptrs[2*i:]))
+			if off >= len(page) {
+				return errors.New("sqlite: invalid cell pointer")
+			}
+			rowid, payload, err := db.leafCell(page[off:])
+			if err != nil {
+				return err
+			}
+			record, err := db.parseRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := f(rowid, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("sqlite: page %d is not a table b-tree page", n)
+	}
+}
+
+// leafCell returns the row ID and payload of a table leaf cell, following
+// its overflow pages.
+func (db *DB) leafCell(cell []byte) (int64, []byte, error) {
+	size, n := varint(cell)
+	if n == 0 || size > 1<<30 {
+		return 0, nil, errors.New("sqlite: invalid cell")
+	}
+	cell = cell[n:]
+	rowid, n := varint(cell)
+	if n == 0 {
+		return 0, nil, errors.New("sqlite: invalid cell")
+	}
+	cell = cell[n:]
+
+	p := int(size)
+	local := p
+	if x := db.usable - 35; p > x {
+		m := (db.usable-12)*32/255 - 23
+		local = m + (p-m)%(db.usable-4)
+		if local > x {
+			local = m
+		}
+	}
+	if local > len(cell) || (local < p && local+4 > len(cell)) {
+		return 0, nil, errors.New("sqlite: invalid cell")
+	}
+	payload := append([]byte(nil), cell[:local]...)
+	if local == p {
+		return int64(rowid), payload, nil
+	}
+	next := int(binary.BigEndian.Uint32(cell[local:]))
+	for len(payload) < p {
+		if next == 0 || len(payload) > db.pages*db.usable {
+			return 0, nil, errors.New("sqlite: invalid overflow chain")
+		}
+		page, err := db.page(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		next = int(binary.BigEndian.Uint32(page))
+		payload = append(payload, page[4:min(db.usable, 4+p-len(payload))]...)
+	}
+	return int64(rowid), payload, nil
+}
+
+// parseRecord decodes the values of a record.
+func (db *DB) parseRecord(payload []byte) ([]any, error) {
+	hdrSize, n := varint(payload)
+	if n == 0 || hdrSize < uint64(n) || hdrSize > uint64(len(payload)) {
+		return nil, errors.New("sqlite: invalid record")
+	}
+	types := payload[n:hdrSize]
+	body := payload[hdrSize:]
+	var values []any
+	for len(types) > 0 {
+		t, n := varint(types)
+		if n == 0 {
+			return nil, errors.New("sqlite: invalid record")
+		}
+		types = types[n:]
+		size := serialSize(t)
+		if size > len(body) {
+			return nil, errors.New("sqlite: truncated record")
+		}
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t >= 1 && t <= 6:
+			values = append(values, signed(v))
+		case t == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case t == 8:
+			values = append(values, int64(0))
+		case t == 9:
+			values = append(values, int64(1))
+		case t >= 12 && t%2 == 0:
+			values = append(values, append([]byte(nil), v...))
+		case t >= 13:
+			values = append(values, db.text(v))
+		default:
+			return nil, fmt.Errorf("sqlite: invalid serial type %d", t)
+		}
+	}
+	return values, nil
+}
+
+// serialSize returns the size of values of serial type t.
+func serialSize(t uint64) int {
+	switch {
+	case t <= 4:
+		return [...]int{0, 1, 2, 3, 4}[t]
+	case t == 5:
+		return 6
+	case t == 6 || t == 7:
+		return 8
+	case t < 12:
+		return 0
+	case t > 1<<31:
+		return math.MaxInt32
+	default:
+		return int(t-12) / 2
+	}
+}
+
+// signed decodes a big-endian two's complement integer.
+func signed(b []byte) int64 {
+	var v int64
+	if b[0]&0x80 != 0 {
+		v = -1
+	}
+	for _, c := range b {
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+// text decodes b in the text encoding of the database.
+func (db *DB) text(b []byte) string {
+	if db.encoding == 1 {
+		return string(b)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if db.encoding == 3 {
+		order = binary.BigEndian
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// varint decodes a variable-length integer, returning it and the number of
+// bytes it takes, or 0 if b is too short.
+func varint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7F)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
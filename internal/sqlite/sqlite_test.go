@@ -0,0 +1,156 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The test databases were made with the sqlite3 Python module:
+//
+//	CREATE TABLE "t" (id INTEGER PRIMARY KEY, name TEXT NOT NULL, n INTEGER,
+//	                  f REAL, b BLOB, CONSTRAINT u UNIQUE (name))
+//
+// in 512-byte pages, with 200 rows ("row i", (-1)^i * i^5, i/4, 3 bytes of
+// i), a row whose name overflows to other pages, and a row with a column
+// added later. wal.db holds the same rows, with two more changes in its
+// write-ahead log wal.db-wal.
+
+func open(t *testing.T, name string, withWAL bool) *DB {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wal []byte
+	if withWAL {
+		if wal, err = os.ReadFile("testdata/" + name + "-wal"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := Open(data, wal)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", name, err)
+	}
+	return db
+}
+
+func TestTable(t *testing.T) {
+	db := open(t, "test.db", false)
+	columns, rows, err := db.Table("t")
+	if err != nil {
+		t.Fatalf("Table: %v", err)
+	}
+	if want := []string{"id", "name", "n", "f", "b", "extra"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("Table: got columns %q, want %q", columns, want)
+	}
+	if len(rows) != 202 {
+		t.Fatalf("Table: got %d rows, want 202", len(rows))
+	}
+	for i, row := range rows[:200] {
+		n := int64(i + 1)
+		want := []any{n, "row " + strconv.FormatInt(n, 10), n * n * n * n * n, float64(n) / 4, bytes.Repeat([]byte{byte(n)}, 3), nil}
+		if n%2 == 1 {
+			want[2] = -want[2].(int64)
+		}
+		if !reflect.DeepEqual(row, want) {
+			t.Fatalf("Table: got row %v, want %v", row, want)
+		}
+	}
+	long := rows[200]
+	if name, _ := long[1].(string); name != "long "+strings.Repeat("x", 3000) || long[2] != -int64(1<<40) {
+		t.Errorf("Table: got overflowing row %.40v, want long name and -2^40", long)
+	}
+	if extra := rows[201]; extra[1] != "with extra" || extra[5] != "e" {
+		t.Errorf("Table: got row %v, want one with extra", extra)
+	}
+
+	if _, _, err := db.Table("missing"); err == nil {
+		t.Error("Table(missing): got nil error")
+	}
+}
+
+func TestWAL(t *testing.T) {
+	for name, want := range map[string]bool{"test.db": false, "wal.db": true} {
+		data, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := IsWAL(data); got != want {
+			t.Errorf("IsWAL(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	_, rows, err := open(t, "wal.db", false).Table("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 202 || rows[0][1] != "row 1" {
+		t.Errorf("Table without the write-ahead log: got %d rows, first named %v; want 202, row 1", len(rows), rows[0][1])
+	}
+
+	_, rows, err = open(t, "wal.db", true).Table("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 203 || rows[0][1] != "renamed" || rows[202][1] != "from wal" {
+		t.Errorf("Table with the write-ahead log: got %d rows, first named %v; want 203, renamed", len(rows), rows[0][1])
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	for _, tt := range []struct {
+		sql     string
+		columns []string
+		real    []bool
+		pk      int
+	}{
+		{"CREATE TABLE accounts (_id INTEGER PRIMARY KEY, email TEXT NOT NULL, secret TEXT NOT NULL, counter INTEGER DEFAULT 0, type INTEGER, provider INTEGER DEFAULT 0, issuer TEXT DEFAULT NULL, original_name TEXT DEFAULT NULL)",
+			[]string{"_id", "email", "secret", "counter", "type", "provider", "issuer", "original_name"},
+			make([]bool, 8), 0},
+		{"CREATE TABLE [a b](\"x,y\" TEXT CHECK (length(\"x,y\") > 0), `z` DOUBLE PRECISION(10, 2), PRIMARY KEY (z))",
+			[]string{"x,y", "z"}, []bool{false, true}, -1},
+		{"CREATE TABLE t (k INTEGER PRIMARY KEY DESC, v FLOAT NOT NULL)", []string{"k", "v"}, []bool{false, true}, -1},
+	} {
+		s, err := parseSchema(tt.sql)
+		if err != nil {
+			t.Errorf("parseSchema(%q): %v", tt.sql, err)
+			continue
+		}
+		if !reflect.DeepEqual(s.columns, tt.columns) || !reflect.DeepEqual(s.real, tt.real) || s.pk != tt.pk {
+			t.Errorf("parseSchema(%q) = %q, %v, %d; want %q, %v, %d", tt.sql, s.columns, s.real, s.pk, tt.columns, tt.real, tt.pk)
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	data, err := os.ReadFile("testdata/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Make the interior pages of t their own right-most children.
+	found := false
+	for off := 512; off+512 <= len(data); off += 512 {
+		if data[off] == interiorTable {
+			binary.BigEndian.PutUint32(data[off+8:], uint32(off/512+1))
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("no interior page in testdata/test.db")
+	}
+	db, err := Open(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.Table("t"); err == nil {
+		t.Error("Table of a corrupted database: got nil error")
+	}
+	if _, err := Open(data[:99], nil); err == nil {
+		t.Error("Open of a truncated header: got nil error")
+	}
+}